@@ -0,0 +1,16 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// cacheCmd groups the subcommands that inspect and manage the cache.
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Inspect and manage the download cache",
+	Long:  `Inspect and manage the cache of downloaded tarballs. See "degit clear" to remove it entirely.`,
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	degit "github.com/qiushiyan/degit/pkg"
+	"github.com/spf13/cobra"
+)
+
+var exportOutput string
+
+var cacheExportCmd = &cobra.Command{
+	Use:   "export -o <bundle.tar> [filter...]",
+	Short: "Write cached templates to a bundle for offline machines",
+	Long: `Write the cached tarballs of the repositories matching the filters, with their cache index entries, into a single tar bundle. A filter with a "#ref" selects only that ref; no filter exports the whole cache. Use "-" as output to write to stdout.
+
+Example:
+
+	degit cache export -o templates.tar myorg/react-template myorg/go-service#v2`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if exportOutput == "" {
+			return errors.New("specify the bundle to write with -o")
+		}
+
+		var w io.Writer = os.Stdout
+		if exportOutput != "-" {
+			f, err := os.Create(exportOutput)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+
+		n, err := degit.ExportCache(w, args)
+		if err != nil {
+			if exportOutput != "-" {
+				os.Remove(exportOutput)
+			}
+			return err
+		}
+		if !Quiet && exportOutput != "-" {
+			fmt.Fprintf(os.Stderr, "exported %d entries to %s\n", n, exportOutput)
+		}
+		return nil
+	},
+}
+
+var cacheImportCmd = &cobra.Command{
+	Use:   "import <bundle.tar>",
+	Short: "Merge a bundle written by degit cache export into the cache",
+	Long:  `Merge a bundle written by "degit cache export" into the cache. Every tarball is verified before anything is merged; tarballs already in the cache are kept. Use "-" to read the bundle from stdin.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var r io.Reader = os.Stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+
+		result, err := degit.ImportCache(r, Verbose)
+		if err != nil {
+			return err
+		}
+		if !Quiet {
+			fmt.Fprintf(os.Stderr, "imported %d entries and %d files (%d already cached)\n",
+				result.Entries, result.Files, result.Skipped)
+		}
+		return nil
+	},
+}
+
+func init() {
+	cacheExportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "bundle to write, or - for stdout")
+	cacheCmd.AddCommand(cacheExportCmd)
+	cacheCmd.AddCommand(cacheImportCmd)
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	degit "github.com/qiushiyan/degit/pkg"
+	"github.com/spf13/cobra"
+)
+
+var gcDryRun bool
+
+var cacheGCCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Repair the cache and remove unreferenced data",
+	Long:  `Bring the cache and its index back in line after crashes or interrupted downloads: drop index entries whose tarballs are missing, delete tarballs and extracted trees no ref refers to, and remove leftover temporary and partial files. Pinned entries are kept as long as their data exists.`,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		result, err := degit.CollectGarbage(gcDryRun, Verbose)
+		if err != nil {
+			return err
+		}
+		if gcDryRun || !Quiet {
+			printGC(os.Stdout, result, gcDryRun)
+		}
+		return nil
+	},
+}
+
+// printGC lists what gc repaired, followed by a summary.
+func printGC(w io.Writer, result *degit.GCResult, dryRun bool) {
+	if len(result.Missing)+len(result.Orphans)+len(result.Temp) == 0 {
+		fmt.Fprintln(w, "cache is consistent, nothing to do")
+		return
+	}
+
+	for _, e := range result.Missing {
+		fmt.Fprintf(w, "missing   %s@%s (%s)\n", e.Repo(), e.Ref, shortHash(e.Hash))
+	}
+	for _, p := range result.Orphans {
+		fmt.Fprintf(w, "orphaned  %s\n", p)
+	}
+	for _, p := range result.Temp {
+		fmt.Fprintf(w, "temporary %s\n", p)
+	}
+
+	format := "dropped %d missing entries, removed %d orphaned and %d temporary files, reclaimed %s\n"
+	if dryRun {
+		format = "would drop %d missing entries, remove %d orphaned and %d temporary files and reclaim %s\n"
+	}
+	fmt.Fprintf(w, format, len(result.Missing), len(result.Orphans), len(result.Temp), formatBytes(result.Freed))
+}
+
+func init() {
+	cacheGCCmd.Flags().BoolVar(&gcDryRun, "dry-run", false, "show what would be repaired without changing anything")
+	cacheCmd.AddCommand(cacheGCCmd)
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	degit "github.com/qiushiyan/degit/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintGC(t *testing.T) {
+	var buf bytes.Buffer
+	printGC(&buf, &degit.GCResult{}, false)
+	require.Equal(t, "cache is consistent, nothing to do\n", buf.String())
+
+	buf.Reset()
+	printGC(&buf, &degit.GCResult{
+		Missing: []degit.CacheEntry{{Site: "github", User: "u", Name: "r", Ref: "v1", Hash: "bbbbbbbbbb"}},
+		Orphans: []string{"github/u/r/zzz.tar.gz"},
+		Temp:    []string{"github/u/r/aaa.tar.gz.part"},
+		Freed:   2048,
+	}, true)
+	require.Equal(t, ""+
+		"missing   github/u/r@v1 (bbbbbbb)\n"+
+		"orphaned  github/u/r/zzz.tar.gz\n"+
+		"temporary github/u/r/aaa.tar.gz.part\n"+
+		"would drop 1 missing entries, remove 1 orphaned and 1 temporary files and reclaim 2.0 KB\n", buf.String())
+}
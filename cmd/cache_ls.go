@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	degit "github.com/qiushiyan/degit/pkg"
+	"github.com/spf13/cobra"
+)
+
+var lsJSON bool
+var lsSort string
+
+var cacheLsCmd = &cobra.Command{
+	Use:   "ls [filter]",
+	Short: "List cached repositories, refs and tarballs",
+	Long:  `List every entry of the cache index: the ref, the path it holds (/ for a whole tarball), its resolved hash, size on disk and last use. Accept an optional argument to filter by the repository.`,
+	Args:  cobra.MatchAll(cobra.MaximumNArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var filter string
+		if len(args) > 0 {
+			filter = args[0]
+		}
+
+		entries, err := degit.ListCache(filter)
+		if err != nil {
+			return err
+		}
+		if err := sortEntries(entries, lsSort); err != nil {
+			return err
+		}
+
+		if lsJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if entries == nil {
+				entries = []degit.CacheEntry{}
+			}
+			return enc.Encode(entries)
+		}
+
+		if len(entries) == 0 {
+			fmt.Fprintln(os.Stderr, "no cache found")
+			return nil
+		}
+		return printEntries(os.Stdout, entries, time.Now())
+	},
+}
+
+// sortEntries orders entries by "name" (repository then ref), "size"
+// (largest first) or "age" (least recently used first).
+func sortEntries(entries []degit.CacheEntry, by string) error {
+	switch by {
+	case "name":
+		// ListCache already returns entries in name order.
+	case "size":
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].Size > entries[j].Size })
+	case "age":
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].LastAccess.Before(entries[j].LastAccess) })
+	default:
+		return fmt.Errorf("invalid sort %q, expected name, size or age", by)
+	}
+	return nil
+}
+
+func printEntries(w io.Writer, entries []degit.CacheEntry, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPO\tREF\tPATH\tHASH\tSIZE\tLAST USED")
+	for _, e := range entries {
+		// Tarballs hold the whole repository; subdir archives and single
+		// files record what they hold.
+		p := e.Path
+		if p == "" {
+			p = "/"
+		}
+		ref := e.Ref
+		if e.Pinned {
+			ref += " (pinned)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Repo(), ref, p, shortHash(e.Hash), formatBytes(e.Size), formatAge(e.LastAccess, now))
+	}
+	return tw.Flush()
+}
+
+func init() {
+	cacheLsCmd.Flags().BoolVar(&lsJSON, "json", false, "print entries as JSON")
+	cacheLsCmd.Flags().StringVar(&lsSort, "sort", "name", "sort by name, size or age")
+	cacheCmd.AddCommand(cacheLsCmd)
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	degit "github.com/qiushiyan/degit/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortEntries(t *testing.T) {
+	now := time.Now()
+	entries := []degit.CacheEntry{
+		{Ref: "a", Size: 1, LastAccess: now},
+		{Ref: "b", Size: 3, LastAccess: now.Add(-time.Hour)},
+		{Ref: "c", Size: 2, LastAccess: now.Add(-2 * time.Hour)},
+	}
+	refs := func() []string {
+		var out []string
+		for _, e := range entries {
+			out = append(out, e.Ref)
+		}
+		return out
+	}
+
+	require.NoError(t, sortEntries(entries, "size"))
+	require.Equal(t, []string{"b", "c", "a"}, refs())
+
+	require.NoError(t, sortEntries(entries, "age"))
+	require.Equal(t, []string{"c", "b", "a"}, refs())
+
+	require.Error(t, sortEntries(entries, "color"))
+}
+
+func TestPrintEntries(t *testing.T) {
+	now := time.Date(2026, 5, 25, 12, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	require.NoError(t, printEntries(&buf, []degit.CacheEntry{{
+		Site: "github", User: "u", Name: "r", Ref: "main",
+		Hash: "abc1234deadbeef", Size: 2048, LastAccess: now.Add(-3 * time.Hour),
+	}, {
+		Site: "github", User: "u", Name: "r", Ref: "main", Path: "/docs",
+		Hash: "abc1234deadbeef", Size: 512, LastAccess: now.Add(-3 * time.Hour),
+	}}, now))
+	require.Equal(t, ""+
+		"REPO        REF   PATH   HASH     SIZE    LAST USED\n"+
+		"github/u/r  main  /      abc1234  2.0 KB  3h ago\n"+
+		"github/u/r  main  /docs  abc1234  512 B   3h ago\n", buf.String())
+}
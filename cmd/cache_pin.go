@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	degit "github.com/qiushiyan/degit/pkg"
+	"github.com/spf13/cobra"
+)
+
+var cachePinCmd = &cobra.Command{
+	Use:   "pin <src#ref>",
+	Short: "Keep a cached ref from being evicted or cleared",
+	Long: `Pin the cached tarball of a ref so that it is never evicted, pruned, cleared or replaced when the ref moves, e.g. to guarantee a template is available offline. The ref must have been cloned before.
+
+Example:
+
+	degit cache pin user/repo#v1.2.0`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return pin(args[0], true)
+	},
+}
+
+var cacheUnpinCmd = &cobra.Command{
+	Use:   "unpin <src#ref>",
+	Short: "Let a pinned ref be evicted and cleared again",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return pin(args[0], false)
+	},
+}
+
+func pin(source string, pinned bool) error {
+	entries, err := degit.PinCache(source, pinned)
+	if err != nil {
+		return err
+	}
+	if Quiet {
+		return nil
+	}
+	verb := "pinned"
+	if !pinned {
+		verb = "unpinned"
+	}
+	if len(entries) == 0 {
+		fmt.Fprintf(os.Stderr, "nothing to do, %s is already %s\n", source, verb)
+		return nil
+	}
+	for _, e := range entries {
+		fmt.Fprintf(os.Stderr, "%s %s@%s (%s)\n", verb, e.Repo(), e.Ref, shortHash(e.Hash))
+	}
+	return nil
+}
+
+func init() {
+	cacheCmd.AddCommand(cachePinCmd)
+	cacheCmd.AddCommand(cacheUnpinCmd)
+}
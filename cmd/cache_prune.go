@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2"
+	degit "github.com/qiushiyan/degit/pkg"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var pruneOlderThan string
+var pruneSite string
+var pruneUser string
+var pruneDryRun bool
+var pruneYes bool
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove stale cache entries",
+	Long: `Remove cached refs that have not been used recently, optionally restricted to a site or user. A tarball is kept as long as a recently used ref still resolves to it.
+
+Example:
+
+	degit cache prune --older-than 30d --site gitlab --user myorg --yes`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if pruneOlderThan == "" && pruneSite == "" && pruneUser == "" {
+			return errors.New("specify at least one of --older-than, --site or --user; use `degit clear` to remove the whole cache")
+		}
+
+		opts := degit.PruneOptions{Site: pruneSite, User: pruneUser, DryRun: true}
+		if pruneOlderThan != "" {
+			d, err := parseAge(pruneOlderThan)
+			if err != nil {
+				return err
+			}
+			opts.OlderThan = d
+		}
+
+		plan, err := degit.PruneCache(opts, Verbose)
+		if err != nil {
+			return err
+		}
+		if len(plan.Refs) == 0 {
+			fmt.Fprintln(os.Stderr, "nothing to prune")
+			return nil
+		}
+
+		if pruneDryRun || !Quiet {
+			for _, e := range plan.Refs {
+				fmt.Printf("%s@%s (%s)\n", e.Repo(), e.Ref, shortHash(e.Hash))
+			}
+		}
+		if pruneDryRun {
+			fmt.Printf("would prune %d refs and reclaim %s\n", len(plan.Refs), formatBytes(plan.Freed))
+			return nil
+		}
+
+		if !pruneYes {
+			if !term.IsTerminal(int(os.Stdin.Fd())) {
+				return errors.New("refusing to prune without confirmation, pass --yes in non-interactive sessions")
+			}
+			var confirm bool
+			err := survey.AskOne(
+				&survey.Confirm{
+					Message: fmt.Sprintf("Prune %d refs and reclaim %s?", len(plan.Refs), formatBytes(plan.Freed)),
+				},
+				&confirm,
+			)
+			if err != nil || !confirm {
+				return err
+			}
+		}
+
+		opts.DryRun = false
+		result, err := degit.PruneCache(opts, Verbose)
+		if err != nil {
+			return err
+		}
+		if !Quiet {
+			fmt.Printf("pruned %d refs, reclaimed %s\n", len(result.Refs), formatBytes(result.Freed))
+		}
+		return nil
+	},
+}
+
+// parseAge parses a duration such as "30d", "2w" or "12h". Days and weeks
+// are accepted on top of the units time.ParseDuration understands.
+func parseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.ParseFloat(n, 64)
+			if err != nil || v < 0 {
+				return 0, fmt.Errorf("invalid age %q, e.g. 30d or 12h", s)
+			}
+			return time.Duration(v * float64(unit)), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q, e.g. 30d or 12h", s)
+	}
+	return d, nil
+}
+
+func init() {
+	cachePruneCmd.Flags().StringVar(&pruneOlderThan, "older-than", "", "only refs not used within this long, e.g. 30d, 2w or 12h")
+	cachePruneCmd.Flags().StringVar(&pruneSite, "site", "", "only repositories on this site, e.g. github or gitlab")
+	cachePruneCmd.Flags().StringVar(&pruneUser, "user", "", "only repositories of this user or organization")
+	cachePruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "show what would be pruned without deleting anything")
+	cachePruneCmd.Flags().BoolVarP(&pruneYes, "yes", "y", false, "do not ask for confirmation")
+	cacheCmd.AddCommand(cachePruneCmd)
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAge(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"30d":  30 * 24 * time.Hour,
+		"2w":   14 * 24 * time.Hour,
+		"1.5d": 36 * time.Hour,
+		"12h":  12 * time.Hour,
+		"90m":  90 * time.Minute,
+	} {
+		got, err := parseAge(in)
+		require.NoError(t, err, in)
+		require.Equal(t, want, got, in)
+	}
+
+	for _, in := range []string{"soon", "-3d", "d"} {
+		_, err := parseAge(in)
+		require.Error(t, err, in)
+	}
+}
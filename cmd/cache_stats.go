@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	degit "github.com/qiushiyan/degit/pkg"
+	"github.com/spf13/cobra"
+)
+
+var statsJSON bool
+var statsTop int
+
+var cacheStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show cache hit rates and savings",
+	Long:  `Show how often clones were served from the cache, how many bytes were downloaded and how many were served from the cache instead, in total and for the most used repositories, along with the cache size by site.`,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stats, err := degit.ReadStats()
+		if err != nil {
+			return err
+		}
+		entries, err := degit.ListCache("")
+		if err != nil {
+			return err
+		}
+
+		report := newStatsReport(stats, entries, statsTop)
+		if statsJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(report)
+		}
+		return printStats(os.Stdout, report)
+	},
+}
+
+type repoStatsRow struct {
+	Repo string `json:"repo"`
+	degit.RepoStats
+}
+
+type statsReport struct {
+	Total degit.RepoStats  `json:"total"`
+	Repos []repoStatsRow   `json:"repos"`
+	Sites map[string]int64 `json:"sites"` // bytes cached per site
+}
+
+// newStatsReport ranks repositories by clones, most first, keeping the top
+// ones, and sizes the cache by site counting every cached file once.
+func newStatsReport(stats *degit.Stats, entries []degit.CacheEntry, top int) statsReport {
+	report := statsReport{Total: stats.Total(), Repos: []repoStatsRow{}, Sites: map[string]int64{}}
+	for repo, s := range stats.Repos {
+		report.Repos = append(report.Repos, repoStatsRow{Repo: repo, RepoStats: *s})
+	}
+	sort.Slice(report.Repos, func(i, j int) bool {
+		a, b := report.Repos[i], report.Repos[j]
+		if a.Hits+a.Misses != b.Hits+b.Misses {
+			return a.Hits+a.Misses > b.Hits+b.Misses
+		}
+		return a.Repo < b.Repo
+	})
+	if top > 0 && len(report.Repos) > top {
+		report.Repos = report.Repos[:top]
+	}
+
+	seen := map[string]bool{}
+	for _, e := range entries {
+		if !seen[e.File] {
+			seen[e.File] = true
+			report.Sites[e.Site] += e.Size
+		}
+	}
+	return report
+}
+
+func printStats(w io.Writer, report statsReport) error {
+	t := report.Total
+	fmt.Fprintf(w, "clones:      %d (%d hits, %d misses, %.0f%% hit rate)\n",
+		t.Hits+t.Misses, t.Hits, t.Misses, 100*t.HitRate())
+	fmt.Fprintf(w, "downloaded:  %s\n", formatBytes(t.BytesDownloaded))
+	fmt.Fprintf(w, "from cache:  %s\n", formatBytes(t.BytesServed))
+
+	if len(report.Repos) > 0 {
+		fmt.Fprintln(w)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "REPO\tHITS\tMISSES\tHIT RATE\tDOWNLOADED\tFROM CACHE")
+		for _, r := range report.Repos {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%.0f%%\t%s\t%s\n",
+				r.Repo, r.Hits, r.Misses, 100*r.HitRate(), formatBytes(r.BytesDownloaded), formatBytes(r.BytesServed))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(report.Sites) > 0 {
+		sites := make([]string, 0, len(report.Sites))
+		for site := range report.Sites {
+			sites = append(sites, site)
+		}
+		sort.Strings(sites)
+
+		fmt.Fprintln(w)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "SITE\tCACHED")
+		for _, site := range sites {
+			fmt.Fprintf(tw, "%s\t%s\n", site, formatBytes(report.Sites[site]))
+		}
+		return tw.Flush()
+	}
+	return nil
+}
+
+func init() {
+	cacheStatsCmd.Flags().BoolVar(&statsJSON, "json", false, "print statistics as JSON")
+	cacheStatsCmd.Flags().IntVar(&statsTop, "top", 10, "number of repositories to list (0 = all)")
+	cacheCmd.AddCommand(cacheStatsCmd)
+}
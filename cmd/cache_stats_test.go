@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	degit "github.com/qiushiyan/degit/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsReport(t *testing.T) {
+	stats := &degit.Stats{Repos: map[string]*degit.RepoStats{
+		"github/u/a":   {Hits: 3, Misses: 1, BytesDownloaded: 1024, BytesServed: 3072},
+		"gitlab/org/b": {Hits: 0, Misses: 1, BytesDownloaded: 2048},
+	}}
+	entries := []degit.CacheEntry{
+		{Site: "github", File: "github/u/a/x.tar.gz", Size: 1024},
+		{Site: "github", File: "github/u/a/x.tar.gz", Size: 1024},
+		{Site: "gitlab", File: "gitlab/org/b/y.tar.gz", Size: 2048},
+	}
+
+	report := newStatsReport(stats, entries, 1)
+	require.Len(t, report.Repos, 1)
+	require.Equal(t, "github/u/a", report.Repos[0].Repo)
+	require.Equal(t, map[string]int64{"github": 1024, "gitlab": 2048}, report.Sites)
+
+	var buf bytes.Buffer
+	require.NoError(t, printStats(&buf, report))
+	require.Equal(t, ""+
+		"clones:      5 (3 hits, 2 misses, 60% hit rate)\n"+
+		"downloaded:  3.0 KB\n"+
+		"from cache:  3.0 KB\n"+
+		"\n"+
+		"REPO        HITS  MISSES  HIT RATE  DOWNLOADED  FROM CACHE\n"+
+		"github/u/a  3     1       75%       1.0 KB      3.0 KB\n"+
+		"\n"+
+		"SITE    CACHED\n"+
+		"github  1.0 KB\n"+
+		"gitlab  2.0 KB\n", buf.String())
+}
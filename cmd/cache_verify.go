@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	degit "github.com/qiushiyan/degit/pkg"
+	"github.com/spf13/cobra"
+)
+
+var verifyQuarantine bool
+
+var cacheVerifyCmd = &cobra.Command{
+	Use:   "verify [filter]",
+	Short: "Check cached tarballs against their recorded checksums",
+	Long: `Re-hash the cached tarballs, subdirectory archives and files, compare them with the SHA-256 recorded when they were downloaded, and decode every archive to the end. Accept an optional filter like "degit clear". Exits with an error when corrupt files are found; with --quarantine they are moved to .quarantine in the cache directory and downloaded again on the next clone.
+
+Example:
+
+	degit cache verify --quarantine 'github:myorg/*'`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var filter string
+		if len(args) > 0 {
+			filter = args[0]
+		}
+
+		result, err := degit.VerifyCache(filter, verifyQuarantine, Verbose)
+		if err != nil {
+			return err
+		}
+		if !Quiet || len(result.Corrupt) > 0 {
+			printVerify(os.Stdout, result, verifyQuarantine)
+		}
+		if len(result.Corrupt) > 0 && !verifyQuarantine {
+			return fmt.Errorf("%d corrupt cache files, run with --quarantine to set them aside", len(result.Corrupt))
+		}
+		return nil
+	},
+}
+
+// printVerify lists the corrupt files with the refs using them, followed by
+// a summary.
+func printVerify(w io.Writer, result *degit.VerifyResult, quarantined bool) {
+	for _, c := range result.Corrupt {
+		fmt.Fprintf(w, "corrupt %s: %v\n", c.File, c.Err)
+		for _, e := range c.Entries {
+			fmt.Fprintf(w, "  used by %s@%s (%s)\n", e.Repo(), e.Ref, shortHash(e.Hash))
+		}
+	}
+	switch {
+	case len(result.Corrupt) == 0:
+		fmt.Fprintf(w, "verified %d cache files, all intact\n", result.Checked)
+	case quarantined:
+		fmt.Fprintf(w, "verified %d cache files, quarantined %d corrupt\n", result.Checked, len(result.Corrupt))
+	default:
+		fmt.Fprintf(w, "verified %d cache files, %d corrupt\n", result.Checked, len(result.Corrupt))
+	}
+}
+
+func init() {
+	cacheVerifyCmd.Flags().BoolVar(&verifyQuarantine, "quarantine", false, "move corrupt files out of the cache and drop their index entries")
+	cacheCmd.AddCommand(cacheVerifyCmd)
+}
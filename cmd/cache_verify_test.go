@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	degit "github.com/qiushiyan/degit/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintVerify(t *testing.T) {
+	var buf bytes.Buffer
+	printVerify(&buf, &degit.VerifyResult{Checked: 3}, false)
+	require.Equal(t, "verified 3 cache files, all intact\n", buf.String())
+
+	buf.Reset()
+	printVerify(&buf, &degit.VerifyResult{
+		Checked: 3,
+		Corrupt: []degit.CorruptFile{{
+			File:    "github/u/r/abc.tar.gz",
+			Entries: []degit.CacheEntry{{Site: "github", User: "u", Name: "r", Ref: "main", Hash: "abcdef0123"}},
+			Err:     errors.New("checksum mismatch"),
+		}},
+	}, true)
+	require.Equal(t, ""+
+		"corrupt github/u/r/abc.tar.gz: checksum mismatch\n"+
+		"  used by github/u/r@main (abcdef0)\n"+
+		"verified 3 cache files, quarantined 1 corrupt\n", buf.String())
+}
@@ -1,105 +1,174 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"path"
-	"path/filepath"
-	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/AlecAivazis/survey/v2"
 	degit "github.com/qiushiyan/degit/pkg"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
+var clearDryRun bool
+var clearYes bool
+
 // clearCmd represents the clear command
 var clearCmd = &cobra.Command{
 	Use:   "clear [filter]",
 	Short: "Clear download caches",
-	Long:  `Clear all existing download caches. Accept an optional argument to filter by the repository.`,
-	Args:  cobra.MatchAll(cobra.MaximumNArgs(1)),
+	Long: `Clear all existing download caches. Accept an optional argument to filter by the repository, either a source such as user/repo or a glob over site:user/name. Without a filter in an interactive terminal, pick the repositories to clear from a list. Pinned entries are kept, see "degit cache pin".
+
+Example:
+
+	degit clear 'github:myorg/*' --yes
+	degit clear 'gitlab:*/*' --dry-run`,
+	Args: cobra.MatchAll(cobra.MaximumNArgs(1)),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		var filter string
 		if len(args) > 0 {
 			filter = args[0]
 		}
 
-		dir := degit.GetCacheDir()
-		if stat, err := os.Stat(dir); err != nil || !stat.IsDir() {
-			fmt.Fprintln(os.Stderr, "no cache found, skipping")
+		repos, err := degit.FindCachedRepos(filter)
+		if err != nil {
+			return err
+		}
+		if len(repos) == 0 {
+			if filter == "" {
+				fmt.Fprintln(os.Stderr, "no cache found, skipping")
+			} else {
+				fmt.Fprintf(os.Stderr, "no cache found for %s\n", filter)
+			}
 			return nil
 		}
 
-		var confirm bool
-
-		if filter == "" {
-			count, err := countRepoLevelDirectories(dir)
-			if err != nil {
-				return err
-			}
-			err = survey.AskOne(
-				&survey.Confirm{
-					Message: fmt.Sprintf(
-						"Are you sure you want to clear caches for %d repositories?",
-						count,
-					),
-				},
-				&confirm,
-			)
-			if err != nil {
+		if filter == "" && !clearDryRun && !clearYes && term.IsTerminal(int(os.Stdin.Fd())) {
+			selected, err := selectRepos(repos, time.Now())
+			if err != nil || len(selected) == 0 {
 				return err
 			}
-		} else {
-			r, err := degit.ParseRepo(filter)
-			if err != nil {
+			return clearRepos(selected)
+		}
+
+		var size int64
+		for _, r := range repos {
+			size += r.Size
+		}
+		if clearDryRun || !Quiet {
+			if err := printClearPlan(os.Stdout, repos); err != nil {
 				return err
 			}
+		}
+		if clearDryRun {
+			fmt.Printf("would clear %d repositories and reclaim up to %s\n", len(repos), formatBytes(size))
+			return nil
+		}
 
-			dir := path.Join(dir, r.Site, r.User, r.Name)
-			if stat, err := os.Stat(dir); err != nil || !stat.IsDir() {
-				fmt.Fprintf(os.Stderr, "no cache found for %s\n", filter)
-				return nil
+		if !clearYes {
+			if !term.IsTerminal(int(os.Stdin.Fd())) {
+				return errors.New("refusing to clear without confirmation, pass --yes in non-interactive sessions")
 			}
-
-			err = survey.AskOne(
+			var confirm bool
+			err := survey.AskOne(
 				&survey.Confirm{
-					Message: fmt.Sprintf("Are you sure you want to clear cache for %s?", filter),
+					Message: fmt.Sprintf("Are you sure you want to clear caches for %d repositories (%s)?", len(repos), formatBytes(size)),
 				},
 				&confirm,
 			)
-
-			if err != nil {
+			if err != nil || !confirm {
 				return err
 			}
 		}
 
-		if confirm {
-			return degit.ClearCache(filter, Verbose)
+		kept, err := degit.ClearCache(filter, Verbose)
+		if err != nil {
+			return err
 		}
-
+		printKept(kept)
 		return nil
 	},
 }
 
-func init() {
-	rootCmd.AddCommand(clearCmd)
+// selectRepos asks which of the cached repositories to clear.
+func selectRepos(repos []degit.CachedRepo, now time.Time) ([]degit.CachedRepo, error) {
+	var picked []int
+	err := survey.AskOne(
+		&survey.MultiSelect{
+			Message:  "Select the repositories to clear:",
+			Options:  repoOptions(repos, now),
+			PageSize: 15,
+		},
+		&picked,
+	)
+	if err != nil {
+		return nil, err
+	}
+	selected := make([]degit.CachedRepo, 0, len(picked))
+	for _, i := range picked {
+		selected = append(selected, repos[i])
+	}
+	return selected, nil
 }
 
-func countRepoLevelDirectories(root string) (int, error) {
-	count := 0
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+// repoOptions renders one aligned line per repository with its size and
+// last use.
+func repoOptions(repos []degit.CachedRepo, now time.Time) []string {
+	width := 0
+	for _, r := range repos {
+		width = max(width, len(r.Repo()))
+	}
+	options := make([]string, len(repos))
+	for i, r := range repos {
+		options[i] = fmt.Sprintf("%-*s  %8s  used %s", width, r.Repo(), formatBytes(r.Size), formatAge(r.LastAccess, now))
+		if r.Pinned > 0 {
+			options[i] += fmt.Sprintf(" (%d pinned)", r.Pinned)
+		}
+	}
+	return options
+}
+
+// clearRepos clears each of the repositories.
+func clearRepos(repos []degit.CachedRepo) error {
+	total := 0
+	for _, r := range repos {
+		kept, err := degit.ClearCache(fmt.Sprintf("%s:%s/%s", r.Site, r.User, r.Name), Verbose)
+		total += kept
 		if err != nil {
 			return err
 		}
-		// site/user/repo
-		if info.IsDir() && depth(root, path) == 3 {
-			count++
+	}
+	printKept(total)
+	return nil
+}
+
+func printKept(kept int) {
+	if kept > 0 && !Quiet {
+		fmt.Fprintf(os.Stderr, "kept %d pinned entries, see \"degit cache unpin\"\n", kept)
+	}
+}
+
+// printClearPlan lists the repositories about to be cleared with their size
+// on disk.
+func printClearPlan(w io.Writer, repos []degit.CachedRepo) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPO\tSIZE\tENTRIES")
+	for _, r := range repos {
+		entries := fmt.Sprint(r.Entries)
+		if r.Pinned > 0 {
+			entries += fmt.Sprintf(" (%d pinned)", r.Pinned)
 		}
-		return nil
-	})
-	return count, err
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Repo(), formatBytes(r.Size), entries)
+	}
+	return tw.Flush()
 }
 
-func depth(root, path string) int {
-	return len(strings.Split(strings.TrimPrefix(path, root), "/"))
+func init() {
+	clearCmd.Flags().BoolVar(&clearDryRun, "dry-run", false, "list the repositories that would be cleared without deleting anything")
+	clearCmd.Flags().BoolVarP(&clearYes, "yes", "y", false, "do not ask for confirmation")
+	rootCmd.AddCommand(clearCmd)
 }
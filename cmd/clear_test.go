@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	degit "github.com/qiushiyan/degit/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintClearPlan(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, printClearPlan(&buf, []degit.CachedRepo{
+		{Site: "github", User: "myorg", Name: "a", Size: 2048, Entries: 2},
+		{Site: "github", User: "myorg", Name: "tpl", Size: 512, Entries: 1, Pinned: 1},
+	}))
+	require.Equal(t, ""+
+		"REPO              SIZE    ENTRIES\n"+
+		"github/myorg/a    2.0 KB  2\n"+
+		"github/myorg/tpl  512 B   1 (1 pinned)\n", buf.String())
+}
+
+func TestRepoOptions(t *testing.T) {
+	now := time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+	options := repoOptions([]degit.CachedRepo{
+		{Site: "github", User: "u", Name: "r", Size: 2048, LastAccess: now.Add(-3 * 24 * time.Hour)},
+		{Site: "gitlab", User: "myorg", Name: "tpl", Size: 512, Pinned: 2},
+	}, now)
+	require.Equal(t, []string{
+		"github/u/r          2.0 KB  used 3d ago",
+		"gitlab/myorg/tpl     512 B  used never (2 pinned)",
+	}, options)
+}
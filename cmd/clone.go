@@ -24,6 +24,10 @@ var cloneCmd = &cobra.Command{
 
 		dst := resolveDestination(repo, args)
 
+		if err := configureRepo(repo); err != nil {
+			return err
+		}
+
 		if stat, err := os.Stat(dst); err == nil {
 			if !Force {
 				return fmt.Errorf("destination `%s` already exists, use --force to overwrite", dst)
@@ -79,6 +83,32 @@ var cloneCmd = &cobra.Command{
 	},
 }
 
+// configureRepo applies the HTTP client, sparse mode, cache modes, URL
+// rewrites and the $DEGIT_ARCHIVE_HOST proxy from the flags, environment
+// and config file to repo.
+func configureRepo(repo *degit.Repo) error {
+	client, err := httpClientFor(repo)
+	if err != nil {
+		return err
+	}
+	repo.HTTPClient = client
+	repo.Sparse = Sparse
+
+	c, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	repo.Trees = TreeCache || c.Cache.Trees
+	repo.Hardlink = Hardlink
+	repo.Refresh = Refresh
+	repo.NoCache = NoCache
+	repo.Verify = Verify || c.Cache.Verify
+	repo.ArchiveHost = os.Getenv("DEGIT_ARCHIVE_HOST")
+
+	repo.Rewrites, err = rewriteRules()
+	return err
+}
+
 // resolveDestination applies cp-like semantics for file targets:
 //   - omitted dst: file basename (or subdir / repo name for folder targets)
 //   - dst is an existing directory: write inside it using the file's basename
@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	degit "github.com/qiushiyan/degit/pkg"
+)
+
+// config mirrors the optional JSON configuration file. It is read from
+// $DEGIT_CONFIG, or <user config dir>/degit/config.json when unset.
+//
+//	{
+//	  "cache": {"max_size": "5GB", "max_entries": 200, "keep_hashes": 3, "keep_for": "7d", "trees": true, "verify": true},
+//	  "hosts": {
+//	    "gitlab.example.com": {
+//	      "timeout": "30s",
+//	      "cacert": "/etc/ssl/corp-root.pem",
+//	      "cert": "/home/me/.degit/client.pem",
+//	      "key": "/home/me/.degit/client.key"
+//	    }
+//	  }
+//	}
+type config struct {
+	Cache cacheConfig           `json:"cache"`
+	Hosts map[string]hostConfig `json:"hosts"`
+}
+
+// cacheConfig bounds and tunes the cache, see degit.CacheLimits and
+// degit.CacheRetention.
+type cacheConfig struct {
+	MaxSize    string `json:"max_size"`
+	MaxEntries int    `json:"max_entries"`
+	KeepHashes int    `json:"keep_hashes"`
+	KeepFor    string `json:"keep_for"`
+	Trees      bool   `json:"trees"`  // see degit.Repo.Trees
+	Verify     bool   `json:"verify"` // see degit.Repo.Verify
+}
+
+// hostConfig holds per-host HTTP settings, keyed by the host of Repo.URL.
+type hostConfig struct {
+	Timeout string `json:"timeout"`
+	CACert  string `json:"cacert"`
+	Cert    string `json:"cert"`
+	Key     string `json:"key"`
+}
+
+func configPath() string {
+	if p := os.Getenv("DEGIT_CONFIG"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "degit", "config.json")
+}
+
+// loadConfig reads the configuration file. A missing file is not an error
+// and yields an empty config.
+func loadConfig() (*config, error) {
+	c := &config{}
+	p := configPath()
+	if p == "" {
+		return c, nil
+	}
+	b, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", p, err)
+	}
+	return c, nil
+}
+
+// clientOptions merges the config entry for host with the command line
+// flags; flags take precedence over the config file.
+func (c *config) clientOptions(host string) (degit.ClientOptions, error) {
+	var opts degit.ClientOptions
+	if h, ok := c.Hosts[host]; ok {
+		if h.Timeout != "" {
+			d, err := time.ParseDuration(h.Timeout)
+			if err != nil {
+				return opts, fmt.Errorf("invalid timeout for host %s: %w", host, err)
+			}
+			opts.Timeout = d
+		}
+		opts.CACert = h.CACert
+		opts.Cert = h.Cert
+		opts.Key = h.Key
+	}
+
+	if Timeout != 0 {
+		opts.Timeout = Timeout
+	}
+	if CACert != "" {
+		opts.CACert = CACert
+	}
+	if Cert != "" {
+		opts.Cert = Cert
+	}
+	if Key != "" {
+		opts.Key = Key
+	}
+	return opts, nil
+}
+
+// cacheLimits merges the config file with $DEGIT_CACHE_MAX_SIZE,
+// $DEGIT_CACHE_MAX_ENTRIES and the command line flags, in increasing order
+// of precedence.
+func (c *config) cacheLimits() (degit.CacheLimits, error) {
+	var limits degit.CacheLimits
+
+	size := firstNonEmpty(CacheMaxSize, os.Getenv("DEGIT_CACHE_MAX_SIZE"), c.Cache.MaxSize)
+	if size != "" {
+		n, err := parseSize(size)
+		if err != nil {
+			return limits, err
+		}
+		limits.MaxSize = n
+	}
+
+	limits.MaxEntries = c.Cache.MaxEntries
+	if env := os.Getenv("DEGIT_CACHE_MAX_ENTRIES"); env != "" {
+		n, err := strconv.Atoi(env)
+		if err != nil {
+			return limits, fmt.Errorf("invalid DEGIT_CACHE_MAX_ENTRIES %q", env)
+		}
+		limits.MaxEntries = n
+	}
+	if CacheMaxEntries != 0 {
+		limits.MaxEntries = CacheMaxEntries
+	}
+	return limits, nil
+}
+
+// cacheRetention merges the config file with $DEGIT_CACHE_KEEP_HASHES,
+// $DEGIT_CACHE_KEEP_FOR and the command line flags, in increasing order of
+// precedence, on top of degit.DefaultCacheRetention.
+func (c *config) cacheRetention() (degit.CacheRetention, error) {
+	retention := degit.DefaultCacheRetention
+
+	if c.Cache.KeepHashes != 0 {
+		retention.Hashes = c.Cache.KeepHashes
+	}
+	if env := os.Getenv("DEGIT_CACHE_KEEP_HASHES"); env != "" {
+		n, err := strconv.Atoi(env)
+		if err != nil {
+			return retention, fmt.Errorf("invalid DEGIT_CACHE_KEEP_HASHES %q", env)
+		}
+		retention.Hashes = n
+	}
+	if CacheKeepHashes != 0 {
+		retention.Hashes = CacheKeepHashes
+	}
+
+	if window := firstNonEmpty(CacheKeepFor, os.Getenv("DEGIT_CACHE_KEEP_FOR"), c.Cache.KeepFor); window != "" {
+		d, err := parseAge(window)
+		if err != nil {
+			return retention, err
+		}
+		retention.Within = d
+	}
+	return retention, nil
+}
+
+// parseSize parses a byte size such as "512MB", "5G" or "1024". Units are
+// binary, matching how sizes are printed.
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+		{"B", 1},
+	}
+
+	num, mult := strings.ToUpper(strings.TrimSpace(s)), int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(num, u.suffix) {
+			num, mult = strings.TrimSpace(strings.TrimSuffix(num, u.suffix)), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q, e.g. 500MB or 5GB", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// httpClientFor returns the client to download repo with, or nil when no
+// flag or config entry applies and the library default should be used.
+func httpClientFor(repo *degit.Repo) (*http.Client, error) {
+	c, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(repo.URL)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := c.clientOptions(u.Host)
+	if err != nil {
+		return nil, err
+	}
+	if opts == (degit.ClientOptions{}) {
+		return nil, nil
+	}
+	return degit.NewHTTPClient(opts)
+}
+
+// rewriteRules collects URL rewrite rules from $DEGIT_URL_REWRITES
+// (";"-separated) followed by the rules file at $DEGIT_URL_REWRITES_FILE,
+// or <user config dir>/degit/rewrites when unset. See degit.ParseRewriteRules
+// for the rule syntax.
+func rewriteRules() ([]degit.RewriteRule, error) {
+	env := strings.ReplaceAll(os.Getenv("DEGIT_URL_REWRITES"), ";", "\n")
+	rules, err := degit.ParseRewriteRules(strings.NewReader(env))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DEGIT_URL_REWRITES: %w", err)
+	}
+
+	p := os.Getenv("DEGIT_URL_REWRITES_FILE")
+	if p == "" {
+		if dir, err := os.UserConfigDir(); err == nil {
+			p = filepath.Join(dir, "degit", "rewrites")
+		}
+	}
+	if p == "" {
+		return rules, nil
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return rules, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fileRules, err := degit.ParseRewriteRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rewrites file %s: %w", p, err)
+	}
+	return append(rules, fileRules...), nil
+}
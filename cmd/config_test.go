@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	degit "github.com/qiushiyan/degit/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, body string) {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(p, []byte(body), 0o644))
+	t.Setenv("DEGIT_CONFIG", p)
+}
+
+func TestLoadConfigMissingFileIsEmpty(t *testing.T) {
+	t.Setenv("DEGIT_CONFIG", filepath.Join(t.TempDir(), "nope.json"))
+	c, err := loadConfig()
+	require.NoError(t, err)
+	require.Empty(t, c.Hosts)
+}
+
+func TestClientOptionsFromConfig(t *testing.T) {
+	writeConfig(t, `{"hosts": {"gitlab.example.com": {"timeout": "30s", "cacert": "/ca.pem"}}}`)
+	c, err := loadConfig()
+	require.NoError(t, err)
+
+	opts, err := c.clientOptions("gitlab.example.com")
+	require.NoError(t, err)
+	require.Equal(t, degit.ClientOptions{Timeout: 30 * time.Second, CACert: "/ca.pem"}, opts)
+
+	opts, err = c.clientOptions("github.com")
+	require.NoError(t, err)
+	require.Equal(t, degit.ClientOptions{}, opts)
+}
+
+func TestClientOptionsFlagsOverrideConfig(t *testing.T) {
+	writeConfig(t, `{"hosts": {"gitlab.example.com": {"timeout": "30s", "cacert": "/ca.pem"}}}`)
+	c, err := loadConfig()
+	require.NoError(t, err)
+
+	Timeout = 5 * time.Second
+	defer func() { Timeout = 0 }()
+
+	opts, err := c.clientOptions("gitlab.example.com")
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Second, opts.Timeout)
+	require.Equal(t, "/ca.pem", opts.CACert)
+}
+
+func TestParseSize(t *testing.T) {
+	for in, want := range map[string]int64{
+		"1024":  1024,
+		"512MB": 512 << 20,
+		"5G":    5 << 30,
+		"1.5kb": 1536,
+		"10 B":  10,
+	} {
+		got, err := parseSize(in)
+		require.NoError(t, err, in)
+		require.Equal(t, want, got, in)
+	}
+
+	_, err := parseSize("lots")
+	require.Error(t, err)
+}
+
+func TestCacheLimitsPrecedence(t *testing.T) {
+	writeConfig(t, `{"cache": {"max_size": "1GB", "max_entries": 10}}`)
+	c, err := loadConfig()
+	require.NoError(t, err)
+
+	limits, err := c.cacheLimits()
+	require.NoError(t, err)
+	require.Equal(t, degit.CacheLimits{MaxSize: 1 << 30, MaxEntries: 10}, limits)
+
+	t.Setenv("DEGIT_CACHE_MAX_SIZE", "2GB")
+	limits, err = c.cacheLimits()
+	require.NoError(t, err)
+	require.Equal(t, int64(2<<30), limits.MaxSize)
+
+	CacheMaxSize, CacheMaxEntries = "3GB", 5
+	defer func() { CacheMaxSize, CacheMaxEntries = "", 0 }()
+	limits, err = c.cacheLimits()
+	require.NoError(t, err)
+	require.Equal(t, degit.CacheLimits{MaxSize: 3 << 30, MaxEntries: 5}, limits)
+}
+
+func TestCacheRetentionPrecedence(t *testing.T) {
+	writeConfig(t, `{}`)
+	c, err := loadConfig()
+	require.NoError(t, err)
+
+	retention, err := c.cacheRetention()
+	require.NoError(t, err)
+	require.Equal(t, degit.DefaultCacheRetention, retention)
+
+	writeConfig(t, `{"cache": {"keep_hashes": 5, "keep_for": "7d"}}`)
+	c, err = loadConfig()
+	require.NoError(t, err)
+	retention, err = c.cacheRetention()
+	require.NoError(t, err)
+	require.Equal(t, degit.CacheRetention{Hashes: 5, Within: 7 * 24 * time.Hour}, retention)
+
+	t.Setenv("DEGIT_CACHE_KEEP_HASHES", "2")
+	CacheKeepFor = "12h"
+	defer func() { CacheKeepFor = "" }()
+	retention, err = c.cacheRetention()
+	require.NoError(t, err)
+	require.Equal(t, degit.CacheRetention{Hashes: 2, Within: 12 * time.Hour}, retention)
+}
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	degit "github.com/qiushiyan/degit/pkg"
+	"github.com/spf13/cobra"
+)
+
+var fetchFromFile string
+var fetchJobs int
+
+var fetchCmd = &cobra.Command{
+	Use:   "fetch <src>...",
+	Short: "Download repositories into the cache without extracting them",
+	Long: `Resolve each source and download its tarball into the cache, without writing any destination, so that later clones work offline. Sources are fetched concurrently and summarized in a table of resolved hashes.
+
+Example:
+
+	degit fetch user/repo gitlab:org/tpl#v2
+	degit fetch --from-file templates.txt`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sources := args
+		if fetchFromFile != "" {
+			fromFile, err := readSources(fetchFromFile)
+			if err != nil {
+				return err
+			}
+			sources = append(sources, fromFile...)
+		}
+		if len(sources) == 0 {
+			return errors.New("specify at least one source, or --from-file")
+		}
+		if NoCache {
+			return errors.New("--no-cache can't be used with fetch, which only writes the cache")
+		}
+
+		results := fetchAll(sources, max(fetchJobs, 1))
+		if !Quiet {
+			printFetchResults(os.Stdout, results)
+		}
+
+		failed := 0
+		for _, r := range results {
+			if r.err != nil {
+				failed++
+				if Quiet {
+					fmt.Fprintf(os.Stderr, "%s: %v\n", r.source, r.err)
+				}
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d sources could not be fetched", failed, len(results))
+		}
+		return nil
+	},
+}
+
+type fetchResult struct {
+	source     string
+	repo       *degit.Repo
+	downloaded bool
+	err        error
+}
+
+// fetchAll fetches sources with up to jobs at a time, returning the results
+// in the order of sources.
+func fetchAll(sources []string, jobs int) []fetchResult {
+	results := make([]fetchResult, len(sources))
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = fetchOne(source)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func fetchOne(source string) fetchResult {
+	result := fetchResult{source: source}
+	repo, err := degit.ParseRepo(source)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	result.repo = repo
+	if result.err = configureRepo(repo); result.err != nil {
+		return result
+	}
+	result.downloaded, result.err = repo.Fetch(Verbose)
+	return result
+}
+
+func printFetchResults(w io.Writer, results []fetchResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tREF\tHASH\tSTATUS")
+	for _, r := range results {
+		ref, hash := "-", "-"
+		if r.repo != nil {
+			ref = r.repo.Ref
+			if r.repo.Hash != "" {
+				hash = shortHash(r.repo.Hash)
+			}
+		}
+		var status string
+		switch {
+		case r.err != nil:
+			status = "error: " + r.err.Error()
+		case r.downloaded:
+			status = "fetched"
+		default:
+			status = "cached"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.source, ref, hash, status)
+	}
+	return tw.Flush()
+}
+
+// readSources reads one source per line from the file p, or stdin for "-",
+// skipping blank lines and "#" comments.
+func readSources(p string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if p != "-" {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var sources []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sources = append(sources, line)
+	}
+	return sources, scanner.Err()
+}
+
+func init() {
+	fetchCmd.Flags().StringVar(&fetchFromFile, "from-file", "", "read sources from a file, one per line (- for stdin)")
+	fetchCmd.Flags().IntVarP(&fetchJobs, "jobs", "j", 4, "number of sources fetched concurrently")
+	rootCmd.AddCommand(fetchCmd)
+}
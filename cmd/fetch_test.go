@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	degit "github.com/qiushiyan/degit/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSources(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "list.txt")
+	require.NoError(t, os.WriteFile(p, []byte("# templates\nuser/repo\n\n  gitlab:org/tpl#v2  \n"), 0o644))
+
+	sources, err := readSources(p)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user/repo", "gitlab:org/tpl#v2"}, sources)
+}
+
+func TestPrintFetchResults(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, printFetchResults(&buf, []fetchResult{
+		{source: "u/r", repo: &degit.Repo{Ref: "HEAD", Hash: "abc1234deadbeef"}, downloaded: true},
+		{source: "u/s#v1", repo: &degit.Repo{Ref: "v1", Hash: "def5678deadbeef"}},
+		{source: "u/missing", repo: &degit.Repo{Ref: "HEAD"}, err: errors.New("could not find repository")},
+	}))
+	require.Equal(t, ""+
+		"SOURCE     REF   HASH     STATUS\n"+
+		"u/r        HEAD  abc1234  fetched\n"+
+		"u/s#v1     v1    def5678  cached\n"+
+		"u/missing  HEAD  -        error: could not find repository\n", buf.String())
+}
@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"io"
 	"os"
+	"time"
 
+	degit "github.com/qiushiyan/degit/pkg"
 	"github.com/spf13/cobra"
 )
 
@@ -10,6 +13,21 @@ var Verbose bool
 var Force bool
 var NoProgress bool
 var Quiet bool
+var Timeout time.Duration
+var CACert string
+var Cert string
+var Key string
+var Sparse bool
+var CacheDir string
+var CacheMaxSize string
+var CacheMaxEntries int
+var CacheKeepHashes int
+var CacheKeepFor string
+var TreeCache bool
+var Hardlink bool
+var Refresh bool
+var NoCache bool
+var Verify bool
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -21,7 +39,32 @@ Usage:
 
 	degit user/repo#ref output-dir
 
-This will download a tarball for the repository github.com/user/repo at "ref" locally, and extracts it to output-dir. You can specify subdirectories and use Gitlab and Bitbucket repositories as well. degit also maintains a cache of downloaded tarballs that can be cleared with "degit clear".`,
+This will download a tarball for the repository github.com/user/repo at "ref" locally, and extracts it to output-dir. You can specify subdirectories and use Gitlab and Bitbucket repositories as well. degit also maintains a cache of downloaded tarballs that can be cleared with "degit clear".
+
+The cache lives in $DEGIT_CACHE_DIR, $XDG_CACHE_HOME/degit or the platform user cache directory, and can be moved with --cache-dir.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		degit.SetCacheDir(CacheDir)
+		degit.MigrateLegacyCache()
+		if Quiet {
+			degit.LockWaitOutput = io.Discard
+		}
+
+		c, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		limits, err := c.cacheLimits()
+		if err != nil {
+			return err
+		}
+		degit.SetCacheLimits(limits)
+		retention, err := c.cacheRetention()
+		if err != nil {
+			return err
+		}
+		degit.SetCacheRetention(retention)
+		return nil
+	},
 }
 
 func Execute() {
@@ -46,6 +89,38 @@ func init() {
 	rootCmd.PersistentFlags().
 		BoolVarP(&Quiet, "quiet", "q", false, "suppress all non-error output (mutually exclusive with --verbose)")
 	rootCmd.MarkFlagsMutuallyExclusive("quiet", "verbose")
+	rootCmd.PersistentFlags().
+		DurationVar(&Timeout, "timeout", 0, "timeout for archive downloads, e.g. 30s (0 = no timeout)")
+	rootCmd.PersistentFlags().
+		StringVar(&CACert, "cacert", "", "PEM bundle of extra root certificates to trust")
+	rootCmd.PersistentFlags().
+		StringVar(&Cert, "cert", "", "PEM client certificate for mutual TLS")
+	rootCmd.PersistentFlags().
+		StringVar(&Key, "key", "", "PEM private key for --cert")
+	rootCmd.MarkFlagsRequiredTogether("cert", "key")
+	rootCmd.PersistentFlags().
+		BoolVar(&Sparse, "sparse", false, "fetch only the subdirectory with a git partial clone (for huge repositories)")
+	rootCmd.PersistentFlags().
+		StringVar(&CacheDir, "cache-dir", "", "cache directory (default $DEGIT_CACHE_DIR, $XDG_CACHE_HOME/degit or the user cache dir)")
+	rootCmd.PersistentFlags().
+		StringVar(&CacheMaxSize, "cache-max-size", "", "evict least recently used tarballs beyond this cache size, e.g. 5GB")
+	rootCmd.PersistentFlags().
+		IntVar(&CacheMaxEntries, "cache-max-entries", 0, "evict least recently used tarballs beyond this many cached hashes")
+	rootCmd.PersistentFlags().
+		IntVar(&CacheKeepHashes, "cache-keep-hashes", 0, "keep this many hashes per ref cached when the ref moves (default 3)")
+	rootCmd.PersistentFlags().
+		StringVar(&CacheKeepFor, "cache-keep-for", "", "also keep earlier hashes of a ref used within this window, e.g. 7d")
+	rootCmd.PersistentFlags().
+		BoolVar(&TreeCache, "tree-cache", false, "keep an extracted tree per hash in the cache for near-instant repeated clones")
+	rootCmd.PersistentFlags().
+		BoolVar(&Hardlink, "hardlink", false, "with --tree-cache, hardlink files when reflinks are unsupported; treat the output as read-only")
+	rootCmd.PersistentFlags().
+		BoolVar(&Refresh, "refresh", false, "ignore the cache and download again, replacing the cached tarball")
+	rootCmd.PersistentFlags().
+		BoolVar(&NoCache, "no-cache", false, "download through a temporary file without reading or writing the cache")
+	rootCmd.PersistentFlags().
+		BoolVar(&Verify, "verify", false, "check cached tarballs against their recorded checksums before extracting them")
+	rootCmd.MarkFlagsMutuallyExclusive("refresh", "no-cache")
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
 	rootCmd.SilenceUsage = true
 }
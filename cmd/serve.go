@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	degit "github.com/qiushiyan/degit/pkg"
+	"github.com/spf13/cobra"
+)
+
+var serveAddr string
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the cache as a pull-through archive proxy",
+	Long: `Serve GitHub, GitLab and Bitbucket compatible archive URLs from the local cache, downloading each commit upstream once on a miss, plus a /resolve endpoint for refs. Point other degit clients at it with DEGIT_ARCHIVE_HOST so an office or CI fleet shares one cache; they fall back to the origin when the proxy is unreachable.
+
+Example:
+
+	degit serve --addr :8080
+	DEGIT_ARCHIVE_HOST=http://mirror:8080 degit user/repo my-app`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if NoCache {
+			return errors.New("--no-cache can't be used with serve, which serves from the cache")
+		}
+		server := &degit.Server{Configure: configureRepo, Verbose: Verbose}
+		if !Quiet {
+			fmt.Fprintf(os.Stderr, "serving %s on %s\n", degit.GetCacheDir(), serveAddr)
+		}
+		// Archives stream for as long as they take, so only reading the
+		// request headers is bounded.
+		srv := &http.Server{Addr: serveAddr, Handler: server, ReadHeaderTimeout: 10 * time.Second}
+		return srv.ListenAndServe()
+	},
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveAddr, "addr", ":8080", "address to listen on")
+	rootCmd.AddCommand(serveCmd)
+}
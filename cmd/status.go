@@ -5,6 +5,7 @@ import (
 	"io"
 	"path/filepath"
 	"strings"
+	"time"
 
 	degit "github.com/qiushiyan/degit/pkg"
 )
@@ -21,8 +22,14 @@ func printResolved(w io.Writer, r *degit.Repo) {
 	fmt.Fprintf(w, "↓ %s/%s@%s (%s)\n", r.User, r.Name, r.Ref, shortHash(r.Hash))
 }
 
-// printCacheHit announces that the tarball was already on disk.
+// printCacheHit announces that the tarball was already on disk, naming the
+// shared cache layer it was found in, if any.
 func printCacheHit(w io.Writer, r *degit.Repo) {
+	if r.CacheLayer != "" {
+		fmt.Fprintf(w, "↪ using shared cache %s/%s@%s (%s) from %s\n",
+			r.User, r.Name, r.Ref, shortHash(r.Hash), r.CacheLayer)
+		return
+	}
 	fmt.Fprintf(w, "↪ using cache %s/%s@%s (%s)\n",
 		r.User, r.Name, r.Ref, shortHash(r.Hash))
 }
@@ -42,3 +49,35 @@ func shortHash(h string) string {
 	}
 	return h
 }
+
+// formatBytes renders a size in bytes with a binary unit, e.g. "1.5 MB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatAge renders how long ago t was relative to now, e.g. "3d ago".
+func formatAge(t, now time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	}
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"bytes"
 	"testing"
+	"time"
 
 	degit "github.com/qiushiyan/degit/pkg"
 	"github.com/stretchr/testify/require"
@@ -30,6 +31,11 @@ func TestPrintCacheHit(t *testing.T) {
 	r := &degit.Repo{User: "u", Name: "r", Ref: "main", Hash: "abc1234deadbeef"}
 	printCacheHit(&buf, r)
 	require.Equal(t, "↪ using cache u/r@main (abc1234)\n", buf.String())
+
+	buf.Reset()
+	r.CacheLayer = "/mnt/templates"
+	printCacheHit(&buf, r)
+	require.Equal(t, "↪ using shared cache u/r@main (abc1234) from /mnt/templates\n", buf.String())
 }
 
 func TestPrintDoneFolder(t *testing.T) {
@@ -50,3 +56,19 @@ func TestShortHashUnderEight(t *testing.T) {
 	require.Equal(t, "abc123", shortHash("abc123"))
 	require.Equal(t, "abc1234", shortHash("abc1234deadbeef"))
 }
+
+func TestFormatBytes(t *testing.T) {
+	require.Equal(t, "512 B", formatBytes(512))
+	require.Equal(t, "1.5 KB", formatBytes(1536))
+	require.Equal(t, "2.0 MB", formatBytes(2<<20))
+	require.Equal(t, "1.0 GB", formatBytes(1<<30))
+}
+
+func TestFormatAge(t *testing.T) {
+	now := time.Date(2026, 5, 25, 12, 0, 0, 0, time.UTC)
+	require.Equal(t, "never", formatAge(time.Time{}, now))
+	require.Equal(t, "just now", formatAge(now.Add(-10*time.Second), now))
+	require.Equal(t, "5m ago", formatAge(now.Add(-5*time.Minute), now))
+	require.Equal(t, "3h ago", formatAge(now.Add(-3*time.Hour), now))
+	require.Equal(t, "2d ago", formatAge(now.Add(-50*time.Hour), now))
+}
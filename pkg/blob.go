@@ -0,0 +1,137 @@
+package degit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// cloneFile serves file mode from a single cached blob, or fetches the file
+// on its own from the host's raw endpoint instead of downloading the whole
+// archive. It reports whether it handled the clone; when it did not (the
+// tarball is already cached, the host has no raw endpoint, or the raw fetch
+// failed or was not usable) the caller falls back to the tarball.
+func (r *Repo) cloneFile(dst string, verbose bool) (bool, error) {
+	tarball, err := exists(r.getOutputFile(r.Hash))
+	if err != nil {
+		return true, err
+	}
+	if tarball {
+		return false, nil
+	}
+
+	blob := r.getBlobFile(r.Hash)
+	ok, err := exists(blob)
+	if err != nil {
+		return true, err
+	}
+
+	var f *fetched
+	if ok {
+		log(verbose, "using cached file for", r.URL)
+	} else {
+		url := r.rawURL(r.Hash)
+		if url == "" {
+			return false, nil
+		}
+		if err := os.MkdirAll(filepath.Dir(blob), os.ModePerm); err != nil {
+			return true, err
+		}
+		if f, err = r.downloadTo(blob, url, nil, verbose); err != nil {
+			log(verbose, "raw download failed, falling back to the archive:", err)
+			return false, nil
+		}
+		reason, err := unusableBlob(blob, f)
+		if err == nil && reason != "" {
+			log(verbose, reason+", falling back to the archive")
+			if err = os.Remove(blob); err == nil {
+				return false, nil
+			}
+		}
+		if err != nil {
+			return true, err
+		}
+	}
+
+	if err := r.record(blob, KindFile, r.Subdir, f, verbose); err != nil {
+		return true, err
+	}
+	return true, copyFile(blob, dst)
+}
+
+// rawURL returns the host's raw-file URL for r.Subdir at hash, or "" when
+// the host has no raw endpoint degit knows about.
+func (r *Repo) rawURL(hash string) string {
+	file := strings.TrimPrefix(r.Subdir, "/")
+	switch r.Site {
+	case "github":
+		return fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s/%s", r.User, r.Name, hash, file)
+	case "gitlab":
+		return fmt.Sprintf("%s/-/raw/%s/%s", r.URL, hash, file)
+	case "bitbucket":
+		return fmt.Sprintf("%s/raw/%s/%s", r.URL, hash, file)
+	}
+	return ""
+}
+
+// unusableBlob returns why the raw download f of blob cannot be cached as
+// the file, or "" when it can. A response whose final URL left the raw path
+// is likely a login or error page reached by a redirect. Raw endpoints do
+// not report file modes, so files that look executable are left to the
+// archive, which keeps the executable bit.
+func unusableBlob(blob string, f *fetched) (string, error) {
+	requested, err := url.Parse(f.url)
+	if err != nil {
+		return "", err
+	}
+	final, err := url.Parse(f.final)
+	if err != nil {
+		return "", err
+	}
+	if final.Path != requested.Path {
+		return "raw download was redirected to " + f.final, nil
+	}
+
+	in, err := os.Open(blob)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	magic := make([]byte, 4)
+	n, err := io.ReadFull(in, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if bytes.HasPrefix(magic[:n], []byte("#!")) || bytes.Equal(magic[:n], []byte("\x7fELF")) {
+		return "file may be executable", nil
+	}
+	return "", nil
+}
+
+// getBlobFile is the cache path of the single file r.Subdir at hash.
+func (r *Repo) getBlobFile(hash string) string {
+	return path.Join(r.getRepoDir(), blobDirName, hash, strings.TrimPrefix(r.Subdir, "/"))
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
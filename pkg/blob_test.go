@@ -0,0 +1,145 @@
+package degit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newFileRepo(serverURL string) *Repo {
+	return &Repo{
+		Site:   "gitlab",
+		User:   "u",
+		Name:   "r",
+		Ref:    "main",
+		URL:    serverURL,
+		Subdir: "/docs/README.md",
+		IsFile: true,
+		Hash:   "abc",
+	}
+}
+
+func TestRawURL(t *testing.T) {
+	r := &Repo{User: "u", Name: "r", Subdir: "/docs/README.md"}
+
+	r.Site, r.URL = "github", "https://github.com/u/r"
+	require.Equal(t, "https://raw.githubusercontent.com/u/r/abc/docs/README.md", r.rawURL("abc"))
+
+	r.Site, r.URL = "gitlab", "https://gitlab.com/u/r"
+	require.Equal(t, "https://gitlab.com/u/r/-/raw/abc/docs/README.md", r.rawURL("abc"))
+
+	r.Site, r.URL = "bitbucket", "https://bitbucket.org/u/r"
+	require.Equal(t, "https://bitbucket.org/u/r/raw/abc/docs/README.md", r.rawURL("abc"))
+
+	r.Site = "git.sr.ht"
+	require.Empty(t, r.rawURL("abc"))
+}
+
+func TestCloneFileUsesRawEndpointAndCachesBlob(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	dst := filepath.Join(t.TempDir(), "README.md")
+	repo := newFileRepo(server.URL)
+	require.NoError(t, repo.Clone(dst, false, false))
+	require.Equal(t, []string{"/-/raw/abc/docs/README.md"}, paths)
+	require.Equal(t, "hello", readFile(t, dst))
+	require.NoFileExists(t, repo.getOutputFile("abc"), "the archive must not be downloaded")
+
+	// The blob is now cached, so a second clone works without the server.
+	server.Close()
+	dst = filepath.Join(t.TempDir(), "README.md")
+	repo = newFileRepo(server.URL)
+	require.NoError(t, repo.Clone(dst, false, false))
+	require.Equal(t, "hello", readFile(t, dst))
+}
+
+func TestCloneFileFallsBackToArchive(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+
+	archive := writeTarGz(t, []tarEntry{
+		{name: "r-abc/docs/README.md", content: "from archive"},
+	})
+	body, err := os.ReadFile(archive)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repository/archive.tar.gz" {
+			_, _ = w.Write(body)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	dst := filepath.Join(t.TempDir(), "README.md")
+	repo := newFileRepo(server.URL)
+	require.NoError(t, repo.Clone(dst, false, false))
+	require.Equal(t, "from archive", readFile(t, dst))
+	require.NoFileExists(t, repo.getBlobFile("abc"))
+}
+
+func TestCloneFileRejectsUnusableRawResponses(t *testing.T) {
+	for name, raw := range map[string]http.HandlerFunc{
+		"redirected": func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/users/sign_in", http.StatusFound)
+		},
+		"executable": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("#!/bin/sh\necho hi\n"))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+
+			archive := writeTarGz(t, []tarEntry{
+				{name: "r-abc/docs/README.md", content: "from archive", mode: 0o755},
+			})
+			body, err := os.ReadFile(archive)
+			require.NoError(t, err)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/repository/archive.tar.gz":
+					_, _ = w.Write(body)
+				case "/users/sign_in":
+					_, _ = w.Write([]byte("<html>sign in</html>"))
+				default:
+					raw(w, r)
+				}
+			}))
+			defer server.Close()
+
+			dst := filepath.Join(t.TempDir(), "README.md")
+			repo := newFileRepo(server.URL)
+			require.NoError(t, repo.Clone(dst, false, false))
+			require.Equal(t, "from archive", readFile(t, dst))
+			info, err := os.Stat(dst)
+			require.NoError(t, err)
+			require.Equal(t, os.FileMode(0o755), info.Mode().Perm())
+			require.NoFileExists(t, repo.getBlobFile("abc"))
+		})
+	}
+}
+
+func TestCloneFileRejectsPathsOutsideTheRepository(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	repo := newFileRepo(server.URL)
+	repo.Subdir = "/../../../../../../x"
+	err := repo.Clone(filepath.Join(t.TempDir(), "x"), false, false)
+	require.ErrorContains(t, err, "invalid path")
+	require.NoFileExists(t, filepath.Join(filepath.Dir(GetCacheDir()), "x"))
+}
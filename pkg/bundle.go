@@ -0,0 +1,380 @@
+package degit
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ExportCache writes the cached data of the repositories matching filters,
+// with their index entries, to w as a tar bundle that ImportCache reads on
+// another machine. Filters are parsed like clone sources; one with a
+// "#ref" selects only that ref. No filter exports the whole cache. It
+// returns the number of entries exported.
+func ExportCache(w io.Writer, filters []string) (int, error) {
+	var repos []*Repo
+	for _, f := range filters {
+		r, err := ParseRepo(f)
+		if err != nil {
+			return 0, err
+		}
+		if !strings.Contains(f, "#") {
+			r.Ref = ""
+		}
+		repos = append(repos, r)
+	}
+
+	idx, err := loadIndex()
+	if err != nil {
+		return 0, err
+	}
+	bundle := &Index{Version: indexVersion}
+	for _, e := range idx.Entries {
+		// Extracted trees are rebuilt from the tarball on the other side.
+		if e.Kind == KindTree {
+			continue
+		}
+		if len(repos) == 0 || matchesAny(e, repos) {
+			// Objects are local to each cache; the importer links its own.
+			exported := *e
+			exported.Object = ""
+			bundle.Entries = append(bundle.Entries, &exported)
+		}
+	}
+	if len(bundle.Entries) == 0 {
+		return 0, errors.New("no cache entries match")
+	}
+
+	tw := tar.NewWriter(w)
+	b, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if err := writeTarFile(tw, indexName, b); err != nil {
+		return 0, err
+	}
+
+	written := map[string]bool{}
+	for _, e := range bundle.Entries {
+		if written[e.File] {
+			continue
+		}
+		written[e.File] = true
+		if err := exportFile(tw, e); err != nil {
+			return 0, err
+		}
+	}
+	return len(bundle.Entries), tw.Close()
+}
+
+// matchesAny reports whether e belongs to one of repos, and to its ref
+// when one is set.
+func matchesAny(e *CacheEntry, repos []*Repo) bool {
+	for _, r := range repos {
+		if (r.Ref == "" && e.isRepo(r)) || (r.Ref != "" && e.isRef(r)) {
+			return true
+		}
+	}
+	return false
+}
+
+// exportFile adds the cached data of e to tw under the repository lock.
+func exportFile(tw *tar.Writer, e *CacheEntry) error {
+	unlock, err := lockDir(path.Join(GetCacheDir(), e.Site, e.User, e.Name))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := os.Open(path.Join(GetCacheDir(), e.File))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	header := &tar.Header{Name: e.File, Mode: 0644, Size: info.Size(), ModTime: info.ModTime()}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// ImportResult reports what ImportCache merged into the cache.
+type ImportResult struct {
+	Entries int // index entries added or updated
+	Files   int // cached files added
+	Skipped int // files already cached locally, which are kept
+}
+
+// ImportCache merges a bundle written by ExportCache into the cache. Every
+// file is checked against the checksum recorded in the bundle, and archives
+// must be readable to the end, before anything is merged. Files already
+// cached locally are kept. New entries keep the pin they were exported
+// with; an entry already in the index keeps its local pin and takes the
+// later of the two last accesses.
+func ImportCache(r io.Reader, verbose bool) (*ImportResult, error) {
+	base := GetCacheDir()
+	if err := os.MkdirAll(base, os.ModePerm); err != nil {
+		return nil, err
+	}
+	staging, err := os.MkdirTemp(base, ".import-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	bundle, err := stageBundle(r, staging)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{}
+	dirs := map[string][]*CacheEntry{}
+	var order []string
+	for _, e := range bundle.Entries {
+		dir := path.Join(base, e.Site, e.User, e.Name)
+		if _, ok := dirs[dir]; !ok {
+			order = append(order, dir)
+		}
+		dirs[dir] = append(dirs[dir], e)
+	}
+	for _, dir := range order {
+		if err := importRepo(dir, staging, dirs[dir], result, verbose); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// stageBundle extracts the bundle into staging and verifies it, returning
+// its index restricted to the entries whose data it holds.
+func stageBundle(r io.Reader, staging string) (*Index, error) {
+	tr := tar.NewReader(r)
+	header, err := tr.Next()
+	if err != nil || header.Name != indexName {
+		return nil, fmt.Errorf("not a degit cache bundle: missing %s", indexName)
+	}
+	bundle := &Index{}
+	if err := json.NewDecoder(tr).Decode(bundle); err != nil {
+		return nil, fmt.Errorf("invalid bundle index: %w", err)
+	}
+	if bundle.Version > indexVersion {
+		return nil, fmt.Errorf("bundle index version %d is newer than this degit supports (%d), please upgrade", bundle.Version, indexVersion)
+	}
+
+	byFile := map[string]*CacheEntry{}
+	for _, e := range validEntries(bundle.Entries) {
+		byFile[e.File] = e
+	}
+
+	staged := map[string]bool{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		e, ok := byFile[header.Name]
+		if !ok || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		// Names come from the index, but keep a crafted bundle from
+		// writing outside the staging directory, or into the cache dir
+		// of another repository than the entry's, anyway.
+		if !filepath.IsLocal(header.Name) || !inRepoDir(e) {
+			return nil, fmt.Errorf("invalid path %s in bundle", header.Name)
+		}
+		dst := filepath.Join(staging, filepath.FromSlash(header.Name))
+		if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+			return nil, err
+		}
+		if err := stageFile(tr, dst, e); err != nil {
+			return nil, fmt.Errorf("%s: %w", header.Name, err)
+		}
+		staged[header.Name] = true
+	}
+
+	var entries []*CacheEntry
+	for _, e := range validEntries(bundle.Entries) {
+		if staged[e.File] {
+			entries = append(entries, e)
+		}
+	}
+	bundle.Entries = entries
+	return bundle, nil
+}
+
+// inRepoDir reports whether the file of e lies in the cache dir of its
+// own repository.
+func inRepoDir(e *CacheEntry) bool {
+	for _, part := range []string{e.Site, e.User, e.Name} {
+		if part == "." || part == ".." || strings.Contains(part, "/") {
+			return false
+		}
+	}
+	return strings.HasPrefix(e.File, path.Join(e.Site, e.User, e.Name)+"/")
+}
+
+// stageFile writes one file of the bundle to dst and verifies it against e.
+func stageFile(r io.Reader, dst string, e *CacheEntry) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	sum, err := sha256File(dst)
+	if err != nil {
+		return err
+	}
+	if e.SHA256 != "" && sum != e.SHA256 {
+		return errors.New("checksum mismatch")
+	}
+	if e.Kind == KindTarball || e.Kind == KindSubdir {
+		return checkArchive(dst)
+	}
+	return nil
+}
+
+// checkArchive reads the gzipped tarball at file to the end.
+func checkArchive(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("invalid archive: %w", err)
+	}
+	tr := tar.NewReader(gzr)
+	for {
+		_, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid archive: %w", err)
+		}
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return fmt.Errorf("invalid archive: %w", err)
+		}
+	}
+}
+
+// importRepo moves the staged files of one repository into its cache dir
+// and merges their entries into the index, under the repository lock.
+func importRepo(dir, staging string, entries []*CacheEntry, result *ImportResult, verbose bool) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	unlock, err := lockDir(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	base := GetCacheDir()
+	moved := map[string]bool{}
+	for _, e := range entries {
+		if moved[e.File] {
+			continue
+		}
+		moved[e.File] = true
+		dst := path.Join(base, e.File)
+		ok, err := exists(dst)
+		if err != nil {
+			return err
+		}
+		if ok {
+			log(verbose, "keeping cached", dst)
+			result.Skipped++
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.Rename(filepath.Join(staging, filepath.FromSlash(e.File)), dst); err != nil {
+			return err
+		}
+		log(verbose, "imported", dst)
+		result.Files++
+	}
+
+	return updateIndex(func(idx *Index) error {
+		for _, e := range entries {
+			result.Entries += idx.merge(e)
+		}
+		// Link imported tarballs into the object store like downloaded
+		// ones, so forks of the same commit find them.
+		for _, e := range idx.Entries {
+			if e.Kind == KindTarball && e.Object == "" && moved[e.File] {
+				if err := idx.linkObject(e.File, e.Hash, e.SHA256); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// merge adds e to the index, or updates the entry for the same ref and
+// file. It returns 1 when the index changed.
+func (idx *Index) merge(e *CacheEntry) int {
+	for _, local := range idx.Entries {
+		if local.Site != e.Site || local.User != e.User || local.Name != e.Name ||
+			local.Ref != e.Ref || local.File != e.File {
+			continue
+		}
+		if !e.LastAccess.After(local.LastAccess) {
+			return 0
+		}
+		local.LastAccess = e.LastAccess
+		return 1
+	}
+
+	imported := *e
+	// An object path from another machine means nothing here.
+	imported.Object = ""
+	// The local file wins a conflict, so describe that one.
+	for _, local := range idx.Entries {
+		if local.File == e.File {
+			imported.Size, imported.SHA256, imported.FetchedAt = local.Size, local.SHA256, local.FetchedAt
+			imported.Source, imported.Host, imported.Object = local.Source, local.Host, local.Object
+			break
+		}
+	}
+	idx.Entries = append(idx.Entries, &imported)
+	return 1
+}
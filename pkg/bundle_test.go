@@ -0,0 +1,164 @@
+package degit
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// cacheArchive records a valid archive as what source resolved to at hash.
+func cacheArchive(t *testing.T, source, hash string) *Repo {
+	t.Helper()
+	repo, err := ParseRepo(source)
+	require.NoError(t, err)
+	repo.Hash = hash
+	archive := writeTarGz(t, []tarEntry{{name: repo.archivePrefix() + "/README.md", content: source}})
+	body, err := os.ReadFile(archive)
+	require.NoError(t, err)
+
+	file := repo.getOutputFile(hash)
+	require.NoError(t, os.MkdirAll(filepath.Dir(file), 0o755))
+	require.NoError(t, os.WriteFile(file, body, 0o644))
+	require.NoError(t, repo.record(file, KindTarball, "", nil, false))
+	return repo
+}
+
+func TestExportImportCache(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	tpl := cacheArchive(t, "myorg/tpl#main", "aaa")
+	cacheArchive(t, "myorg/tpl#v1", "bbb")
+	cacheArchive(t, "other/repo", "ccc")
+
+	var bundle bytes.Buffer
+	n, err := ExportCache(&bundle, []string{"myorg/tpl"})
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	tr := tar.NewReader(bytes.NewReader(bundle.Bytes()))
+	_, err = tr.Next()
+	require.NoError(t, err)
+	index, err := io.ReadAll(tr)
+	require.NoError(t, err)
+	require.NotContains(t, string(index), `"object"`, "object paths are local to each cache")
+
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	result, err := ImportCache(bytes.NewReader(bundle.Bytes()), false)
+	require.NoError(t, err)
+	require.Equal(t, &ImportResult{Entries: 2, Files: 2}, result)
+
+	entries, err := ListCache("")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.FileExists(t, tpl.getOutputFile("aaa"))
+	require.NoFileExists(t, filepath.Join(GetCacheDir(), "github", "other"))
+	// Imported tarballs are linked into the local object store, so forks
+	// of the same commits are cache hits.
+	for _, e := range entries {
+		require.NotEmpty(t, e.Object)
+		require.FileExists(t, filepath.Join(GetCacheDir(), e.Object))
+	}
+	obj, err := findObject("aaa")
+	require.NoError(t, err)
+	require.NotEmpty(t, obj)
+
+	// Importing again finds everything cached already.
+	result, err = ImportCache(bytes.NewReader(bundle.Bytes()), false)
+	require.NoError(t, err)
+	require.Equal(t, &ImportResult{Skipped: 2}, result)
+}
+
+func TestExportCacheByRef(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	cacheArchive(t, "myorg/tpl#main", "aaa")
+	cacheArchive(t, "myorg/tpl#v1", "bbb")
+
+	n, err := ExportCache(io.Discard, []string{"myorg/tpl#v1"})
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	_, err = ExportCache(io.Discard, []string{"nobody/nothing"})
+	require.Error(t, err)
+}
+
+func TestImportCacheRejectsCorruptBundle(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	cacheArchive(t, "myorg/tpl#main", "aaa")
+	var bundle bytes.Buffer
+	_, err := ExportCache(&bundle, nil)
+	require.NoError(t, err)
+
+	// Flip the tarball's content without touching the recorded checksum.
+	var corrupt bytes.Buffer
+	tr := tar.NewReader(&bundle)
+	tw := tar.NewWriter(&corrupt)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		body, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		if header.Name != indexName {
+			body = bytes.Repeat([]byte{'x'}, len(body))
+		}
+		require.NoError(t, tw.WriteHeader(header))
+		_, err = tw.Write(body)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	_, err = ImportCache(&corrupt, false)
+	require.ErrorContains(t, err, "checksum mismatch")
+
+	entries, err := ListCache("")
+	require.NoError(t, err)
+	require.Empty(t, entries, "nothing is merged from an invalid bundle")
+
+	_, err = ImportCache(bytes.NewReader([]byte("not a tar")), false)
+	require.ErrorContains(t, err, "not a degit cache bundle")
+}
+
+func TestImportCacheRejectsFilesOutsideTheirRepository(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	cacheArchive(t, "myorg/tpl#main", "aaa")
+	var bundle bytes.Buffer
+	_, err := ExportCache(&bundle, nil)
+	require.NoError(t, err)
+
+	// Claim the tarball of myorg/tpl for another repository.
+	var crafted bytes.Buffer
+	tr := tar.NewReader(&bundle)
+	tw := tar.NewWriter(&crafted)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		body, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		if header.Name == indexName {
+			body = bytes.ReplaceAll(body, []byte(`"user": "myorg"`), []byte(`"user": "victim"`))
+			require.Contains(t, string(body), "victim")
+			header.Size = int64(len(body))
+		}
+		require.NoError(t, tw.WriteHeader(header))
+		_, err = tw.Write(body)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	_, err = ImportCache(&crafted, false)
+	require.ErrorContains(t, err, "invalid path")
+
+	entries, err := ListCache("")
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
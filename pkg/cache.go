@@ -1,170 +1,242 @@
 package degit
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 	"path"
-	"time"
+	"path/filepath"
 )
 
+// Per-repository logs of earlier releases, superseded by the index.
 var accessLogName = "access.json"
 var hashLogName = "map.json"
 
+// blobDirName holds single files fetched from raw endpoints, laid out as
+// blobs/<hash>/<path>.
+var blobDirName = "blobs"
+
 // ClearCache remove cache folder for repositories. If filter is empty, all caches are cleared.
-func ClearCache(filter string, verbose bool) error {
+// The filter is either a repository parsed like a clone source, or a glob
+// such as "github:myorg/*" or "gitlab:*/*" matched against site:user/name.
+// Pinned entries and their data are kept; ClearCache returns how many.
+func ClearCache(filter string, verbose bool) (int, error) {
+	match, err := parseRepoFilter(filter)
+	if err != nil {
+		return 0, err
+	}
 	base := GetCacheDir()
 	ok, err := exists(base)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if !ok {
 		if verbose {
 			fmt.Fprintln(os.Stderr, "no cache found")
 		}
-		return nil
-	}
-	if filter == "" {
-		return os.RemoveAll(base)
+		return 0, nil
 	}
 
-	r, err := ParseRepo(filter)
+	idx, err := loadIndex()
 	if err != nil {
-		return err
+		return 0, err
+	}
+	kept := 0
+	for _, e := range idx.Entries {
+		if e.Pinned && match(e.Site, e.User, e.Name) {
+			kept++
+		}
+	}
+	if filter == "" && kept == 0 {
+		return 0, wipeCache(base)
 	}
 
-	dir := path.Join(base, r.Site, r.User, r.Name)
-	ok, err = exists(dir)
+	repos, err := repoDirs(base)
 	if err != nil {
-		return err
+		return kept, err
 	}
-	if !ok {
-		if verbose {
-			fmt.Fprintf(os.Stderr, "no cache found for %s\n", filter)
+	cleared := 0
+	for _, d := range repos {
+		if !match(d.site, d.user, d.name) {
+			continue
+		}
+		if err := clearRepoDir(d.path, idx.pinnedFiles()); err != nil {
+			return kept, err
 		}
-		return nil
+		cleared++
 	}
-
-	return os.RemoveAll(dir)
-}
-
-// GetCacheDir returns the cache directory, usually $HOME/.go-degit
-func GetCacheDir() string {
-	return path.Join(homeOrTmp(), ".go-degit")
+	if cleared == 0 && verbose {
+		fmt.Fprintf(os.Stderr, "no cache found for %s\n", filter)
+	}
+	return kept, nil
 }
 
-func updateCache(dir string, ref string, hash string, verbose bool) error {
-	if err := updateAccessLog(dir, ref); err != nil {
+// wipeCache deletes the whole cache dir base under the cache-wide lock.
+func wipeCache(base string) error {
+	unlock, err := lockDir(base)
+	if err != nil {
 		return err
 	}
-
-	if err := updateHashLog(dir, ref, hash, verbose); err != nil {
+	err = removeAllButLocks(base)
+	unlock()
+	if err != nil || keepLockFiles {
 		return err
 	}
-	return nil
+	return os.RemoveAll(base)
 }
 
-func updateAccessLog(dir string, ref string) error {
-	path := path.Join(dir, accessLogName)
-
-	// Open the file with read-write permissions, create if it doesn't exist
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	var data map[string]any = make(map[string]any)
-
-	// Read the existing contents if the file is not empty
-	s, err := io.ReadAll(f)
+// clearRepoDir deletes the cached data in the repository cache dir, except
+// for the pinned files (relative to the cache dir), and drops the matching
+// entries from the index. The rest of the directory is removed when nothing
+// in it is pinned.
+func clearRepoDir(dir string, pinned map[string]bool) error {
+	base := GetCacheDir()
+	unlock, err := lockDir(dir)
 	if err != nil {
 		return err
 	}
 
-	if len(s) != 0 {
-		err = json.Unmarshal(s, &data)
+	keep := false
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.Name() == lockFileName {
+			return err
+		}
+		rel, err := filepath.Rel(base, p)
 		if err != nil {
 			return err
 		}
+		if pinned[filepath.ToSlash(rel)] {
+			// A pinned extracted tree is kept as a whole.
+			keep = true
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		return os.Remove(p)
+	})
+	if err == nil {
+		err = updateIndex(func(idx *Index) error {
+			var dropped []*CacheEntry
+			idx.Entries, dropped = partition(idx.Entries, func(e *CacheEntry) bool {
+				return path.Join(base, e.Site, e.User, e.Name) == dir && !pinned[e.File]
+			})
+			// The files are gone already; this drops the objects they
+			// linked to once no other repository does.
+			idx.removeUnreferenced(dropped)
+			return nil
+		})
+	}
+	if err == nil && !keep {
+		err = removeAllButLocks(dir)
+	}
+	unlock()
+	if err != nil || keep || keepLockFiles {
+		return err
 	}
+	return os.RemoveAll(dir)
+}
 
-	// Update the data with the new reference and timestamp
-	data[ref] = time.Now()
-	s, err = json.Marshal(data)
+// removeAllButLocks removes everything in dir except lock files and the
+// directories holding them.
+func removeAllButLocks(dir string) error {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return err
 	}
-
-	// Truncate the file to ensure it's empty before writing the new data
-	if err := f.Truncate(0); err != nil {
-		return err
+	for _, e := range entries {
+		p := path.Join(dir, e.Name())
+		switch {
+		case e.Name() == lockFileName:
+		case e.IsDir():
+			if err := removeAllButLocks(p); err != nil {
+				return err
+			}
+			// Fails, keeping the directory, while it holds a lock file.
+			os.Remove(p)
+		default:
+			if err := os.Remove(p); err != nil {
+				return err
+			}
+		}
 	}
+	return nil
+}
 
-	// Move the file pointer to the beginning of the file
-	if _, err := f.Seek(0, 0); err != nil {
-		return err
-	}
+var cacheDirOverride string
 
-	// Write the updated data
-	_, err = f.Write(s)
-	return err
+// SetCacheDir overrides the cache directory for the rest of the process,
+// taking precedence over the environment. An empty dir restores the default
+// lookup described in GetCacheDir.
+func SetCacheDir(dir string) {
+	cacheDirOverride = dir
 }
 
-func updateHashLog(dir string, ref string, hash string, verbose bool) error {
-	p := path.Join(dir, hashLogName)
+// legacyCacheDir is the cache dir of earlier releases when
+// MigrateLegacyCache could not move it, and "" otherwise.
+var legacyCacheDir string
 
-	// Open the file with read-write permissions, create if it doesn't exist
-	f, err := os.OpenFile(p, os.O_CREATE|os.O_RDWR, 0644)
-	if err != nil {
-		return err
+// GetCacheDir returns the cache directory. In order of precedence it is the
+// directory passed to SetCacheDir, $DEGIT_CACHE_DIR, $XDG_CACHE_HOME/degit,
+// or degit/ under the platform's user cache directory (~/.cache/degit on
+// Linux). See MigrateLegacyCache for caches of earlier releases.
+func GetCacheDir() string {
+	if cacheDirOverride != "" {
+		return cacheDirOverride
 	}
-	defer f.Close()
-
-	var data = make(map[string]any)
-
-	// Read the existing contents if the file is not empty
-	s, err := io.ReadAll(f)
-	if err != nil {
-		return err
+	if dir := os.Getenv("DEGIT_CACHE_DIR"); dir != "" {
+		return dir
 	}
-
-	if len(s) != 0 {
-		err = json.Unmarshal(s, &data)
-		if err != nil {
-			return err
-		}
+	if legacyCacheDir != "" {
+		return legacyCacheDir
 	}
+	return defaultCacheDir()
+}
 
-	// Check and remove the outdated cache file if the hash has changed
-	if oldHash, ok := data[ref]; ok {
-		if oldHash != hash {
-			oldFile := path.Join(dir, fmt.Sprintf("%s.tar.gz", oldHash))
-			os.Remove(oldFile)
-			log(verbose, "removing outdated cache", oldFile)
-		}
+// MigrateLegacyCache moves a cache left at the legacy $HOME/.go-degit
+// location to the default one, unless the cache dir is set through
+// SetCacheDir or $DEGIT_CACHE_DIR. The CLI calls it once at startup.
+func MigrateLegacyCache() {
+	legacyCacheDir = ""
+	if cacheDirOverride != "" || os.Getenv("DEGIT_CACHE_DIR") != "" {
+		return
 	}
-	data[ref] = hash
-
-	s, err = json.Marshal(data)
-	if err != nil {
-		return err
+	dir := defaultCacheDir()
+	if used := migrateLegacyCache(dir); used != dir {
+		legacyCacheDir = used
 	}
+}
 
-	// Truncate the file to ensure it's empty before writing the new data
-	if err := f.Truncate(0); err != nil {
-		return err
+func defaultCacheDir() string {
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return path.Join(dir, "degit")
 	}
-
-	// Move the file pointer to the beginning of the file
-	if _, err := f.Seek(0, 0); err != nil {
-		return err
+	if dir, err := os.UserCacheDir(); err == nil {
+		return path.Join(dir, "degit")
 	}
+	return path.Join(os.TempDir(), "degit")
+}
 
-	// Write the updated data
-	_, err = f.Write(s)
-	return err
+// migrateLegacyCache moves $HOME/.go-degit to dir when only the former
+// exists. If the move fails (e.g. across devices) the legacy directory
+// keeps being used, so no cached tarball is lost.
+func migrateLegacyCache(dir string) string {
+	legacy := path.Join(homeOrTmp(), ".go-degit")
+	if ok, err := exists(legacy); err != nil || !ok {
+		return dir
+	}
+	if ok, err := exists(dir); err != nil || ok {
+		return dir
+	}
+	if err := os.MkdirAll(path.Dir(dir), os.ModePerm); err != nil {
+		return legacy
+	}
+	if err := os.Rename(legacy, dir); err != nil {
+		return legacy
+	}
+	return dir
 }
 
 func homeOrTmp() string {
@@ -0,0 +1,88 @@
+package degit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCacheDirPrecedence(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(home, "xdg"))
+	t.Setenv("DEGIT_CACHE_DIR", "")
+
+	require.Equal(t, filepath.Join(home, "xdg", "degit"), GetCacheDir())
+
+	t.Setenv("DEGIT_CACHE_DIR", "/ci/cache")
+	require.Equal(t, "/ci/cache", GetCacheDir())
+
+	SetCacheDir("/flag/cache")
+	defer SetCacheDir("")
+	require.Equal(t, "/flag/cache", GetCacheDir())
+}
+
+func TestGetCacheDirMigratesLegacyCache(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(home, "xdg"))
+	t.Setenv("DEGIT_CACHE_DIR", "")
+
+	legacy := filepath.Join(home, ".go-degit", "github", "u", "r")
+	require.NoError(t, os.MkdirAll(legacy, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(legacy, "abc.tar.gz"), []byte("x"), 0o644))
+
+	dir := GetCacheDir()
+	require.DirExists(t, legacy, "looking up the cache dir must not move it")
+
+	MigrateLegacyCache()
+	require.Equal(t, dir, GetCacheDir())
+	require.Equal(t, filepath.Join(home, "xdg", "degit"), dir)
+	require.FileExists(t, filepath.Join(dir, "github", "u", "r", "abc.tar.gz"))
+	require.NoDirExists(t, filepath.Join(home, ".go-degit"))
+}
+
+// requireCleared checks that nothing but lock files is left in dir.
+func requireCleared(t *testing.T, dir string) {
+	t.Helper()
+	var left []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && info.Name() != lockFileName {
+			left = append(left, p)
+		}
+		return err
+	})
+	if !os.IsNotExist(err) {
+		require.NoError(t, err)
+	}
+	require.Empty(t, left)
+}
+
+func TestClearCacheKeepsLockFiles(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	dir := seedRepoCache(t, "github/u/r", map[string]string{"main": "aaa"}, nil, 10)
+	unlock, err := lockDir(dir)
+	require.NoError(t, err)
+	unlock()
+
+	_, err = ClearCache("u/r", false)
+	require.NoError(t, err)
+	requireCleared(t, dir)
+	if keepLockFiles {
+		require.FileExists(t, filepath.Join(dir, lockFileName))
+	}
+	repos, err := FindCachedRepos("")
+	require.NoError(t, err)
+	require.Empty(t, repos)
+
+	seedRepoCache(t, "github/u/r", map[string]string{"main": "aaa"}, nil, 10)
+	_, err = ClearCache("", false)
+	require.NoError(t, err)
+	requireCleared(t, GetCacheDir())
+	if keepLockFiles {
+		require.FileExists(t, filepath.Join(GetCacheDir(), lockFileName))
+		require.FileExists(t, filepath.Join(dir, lockFileName))
+	}
+}
@@ -0,0 +1,69 @@
+package degit
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ClientOptions configures the HTTP client used to download archives.
+// The zero value yields a client equivalent to http.DefaultClient.
+type ClientOptions struct {
+	// Timeout bounds each request, including reading the response body.
+	// Zero means no timeout.
+	Timeout time.Duration
+	// CACert is a PEM bundle of extra root certificates, trusted in
+	// addition to the system roots (e.g. a corporate root CA).
+	CACert string
+	// Cert and Key are a PEM client certificate and private key for
+	// servers that require mutual TLS. Both must be set together.
+	Cert string
+	Key  string
+}
+
+// NewHTTPClient builds an *http.Client from opts, suitable for Repo.HTTPClient.
+func NewHTTPClient(opts ClientOptions) (*http.Client, error) {
+	if (opts.Cert == "") != (opts.Key == "") {
+		return nil, errors.New("client certificate and key must be provided together")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if opts.CACert != "" || opts.Cert != "" {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+		if opts.CACert != "" {
+			pool, err := x509.SystemCertPool()
+			if err != nil || pool == nil {
+				pool = x509.NewCertPool()
+			}
+			pem, err := os.ReadFile(opts.CACert)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", opts.CACert)
+			}
+			tlsConfig.RootCAs = pool
+		}
+
+		if opts.Cert != "" {
+			cert, err := tls.LoadX509KeyPair(opts.Cert, opts.Key)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   opts.Timeout,
+	}, nil
+}
@@ -0,0 +1,66 @@
+package degit
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHTTPClientTrustsCACert(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// Without the bundle the self-signed server certificate is rejected.
+	plain, err := NewHTTPClient(ClientOptions{})
+	require.NoError(t, err)
+	_, err = plain.Get(server.URL)
+	require.Error(t, err)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(block), 0o644))
+
+	client, err := NewHTTPClient(ClientOptions{CACert: caFile, Timeout: 5 * time.Second})
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Second, client.Timeout)
+
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestNewHTTPClientRejectsInvalidOptions(t *testing.T) {
+	_, err := NewHTTPClient(ClientOptions{Cert: "client.pem"})
+	require.Error(t, err, "cert without key must be rejected")
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	require.NoError(t, os.WriteFile(empty, nil, 0o644))
+	_, err = NewHTTPClient(ClientOptions{CACert: empty})
+	require.Error(t, err, "a bundle without certificates must be rejected")
+}
+
+func TestDownloadUsesInjectedClient(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("payload"))
+	}))
+	defer server.Close()
+
+	repo := newTestRepo(server.URL, nil)
+	repo.HTTPClient = server.Client()
+	dst := filepath.Join(t.TempDir(), "out.tar.gz")
+
+	require.NoError(t, repo.download(dst, "deadbeef", false))
+
+	got, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	require.Equal(t, "payload", string(got))
+}
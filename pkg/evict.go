@@ -0,0 +1,139 @@
+package degit
+
+import (
+	"path"
+	"sort"
+	"time"
+)
+
+// CacheLimits bounds the size of the cache. Zero fields are unlimited.
+type CacheLimits struct {
+	MaxSize    int64 // total bytes of cached data
+	MaxEntries int   // number of cached files across all repositories
+}
+
+var cacheLimits CacheLimits
+
+// SetCacheLimits sets the limits enforced after every Clone. Without a call
+// the cache grows unbounded.
+func SetCacheLimits(limits CacheLimits) {
+	cacheLimits = limits
+}
+
+// EvictCache removes the least recently used cached files (tarballs, subdir
+// archives and single files) until the cache fits within limits, or only
+// pinned files are left. Recency is the latest access recorded in the index
+// for any ref using the file. It returns the number of bytes freed.
+func EvictCache(limits CacheLimits, verbose bool) (int64, error) {
+	return evictCache(limits, "", "", verbose)
+}
+
+// cachedFile groups the index entries sharing one cached file; it is the
+// unit of eviction.
+type cachedFile struct {
+	dir        string // repository cache dir
+	file       string // relative to the cache dir
+	hash       string
+	size       int64
+	lastAccess time.Time
+	pinned     bool
+}
+
+// evictCache is EvictCache, except that the files of keepHash in the
+// repository cache dir keepDir are never evicted: a clone has just used them.
+func evictCache(limits CacheLimits, keepDir string, keepHash string, verbose bool) (int64, error) {
+	if limits.MaxSize <= 0 && limits.MaxEntries <= 0 {
+		return 0, nil
+	}
+
+	files, err := listFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	count := len(files)
+
+	var freed int64
+	for _, f := range files {
+		overSize := limits.MaxSize > 0 && total > limits.MaxSize
+		overCount := limits.MaxEntries > 0 && count > limits.MaxEntries
+		if !overSize && !overCount {
+			break
+		}
+		if f.pinned || (f.dir == keepDir && f.hash == keepHash) {
+			continue
+		}
+		if err := evictFile(f, verbose); err != nil {
+			return freed, err
+		}
+		total -= f.size
+		freed += f.size
+		count--
+	}
+	return freed, nil
+}
+
+// listFiles returns every cached file, least recently used first.
+func listFiles() ([]*cachedFile, error) {
+	idx, err := loadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*cachedFile
+	byFile := make(map[string]*cachedFile)
+	for _, e := range idx.Entries {
+		f, ok := byFile[e.File]
+		if !ok {
+			f = &cachedFile{
+				dir:  path.Join(GetCacheDir(), e.Site, e.User, e.Name),
+				file: e.File,
+				hash: e.Hash,
+				size: e.Size,
+			}
+			byFile[e.File] = f
+			files = append(files, f)
+		}
+		if e.LastAccess.After(f.lastAccess) {
+			f.lastAccess = e.LastAccess
+		}
+		f.pinned = f.pinned || e.Pinned
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].lastAccess.Before(files[j].lastAccess)
+	})
+	return files, nil
+}
+
+// evictFile drops the index entries of f and deletes its data, under the
+// repository lock.
+func evictFile(f *cachedFile, verbose bool) error {
+	unlock, err := lockDir(f.dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	log(verbose, "evicting least recently used cache", path.Join(GetCacheDir(), f.file))
+	_, err = dropEntries(func(e *CacheEntry) bool { return e.File == f.file })
+	return err
+}
+
+// dropEntries removes the index entries selected by drop and deletes the
+// data no remaining entry refers to, returning the bytes freed. The caller
+// holds the lock of every repository directory involved.
+func dropEntries(drop func(*CacheEntry) bool) (int64, error) {
+	var freed int64
+	err := updateIndex(func(idx *Index) error {
+		var dropped []*CacheEntry
+		idx.Entries, dropped = partition(idx.Entries, drop)
+		freed = idx.removeUnreferenced(dropped)
+		return nil
+	})
+	return freed, err
+}
@@ -0,0 +1,66 @@
+package degit
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvictCacheRemovesLeastRecentlyUsed(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	now := time.Now()
+
+	old := seedRepoCache(t, "github/u/old",
+		map[string]string{"main": "aaa", "HEAD": "aaa"},
+		map[string]time.Time{"main": now.Add(-48 * time.Hour), "HEAD": now.Add(-72 * time.Hour)}, 100)
+	mid := seedRepoCache(t, "github/u/mid",
+		map[string]string{"main": "bbb"},
+		map[string]time.Time{"main": now.Add(-24 * time.Hour)}, 100)
+	recent := seedRepoCache(t, "github/u/recent",
+		map[string]string{"main": "ccc"},
+		map[string]time.Time{"main": now}, 100)
+
+	freed, err := EvictCache(CacheLimits{MaxSize: 250}, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), freed)
+
+	require.NoFileExists(t, filepath.Join(old, "aaa.tar.gz"))
+	require.FileExists(t, filepath.Join(mid, "bbb.tar.gz"))
+	require.FileExists(t, filepath.Join(recent, "ccc.tar.gz"))
+
+	entries, err := ListCache("github:u/old")
+	require.NoError(t, err)
+	require.Empty(t, entries, "evicted refs must be dropped from the index")
+
+	freed, err = EvictCache(CacheLimits{MaxEntries: 1}, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), freed)
+	require.NoFileExists(t, filepath.Join(mid, "bbb.tar.gz"))
+	require.FileExists(t, filepath.Join(recent, "ccc.tar.gz"))
+}
+
+func TestEvictCacheKeepsHashJustUsed(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	dir := seedRepoCache(t, "github/u/r",
+		map[string]string{"main": "aaa"},
+		map[string]time.Time{"main": time.Now()}, 100)
+
+	freed, err := evictCache(CacheLimits{MaxSize: 10}, dir, "aaa", false)
+	require.NoError(t, err)
+	require.Zero(t, freed)
+	require.FileExists(t, filepath.Join(dir, "aaa.tar.gz"))
+}
+
+func TestEvictCacheUnlimitedIsNoOp(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	dir := seedRepoCache(t, "github/u/r",
+		map[string]string{"main": "aaa"},
+		map[string]time.Time{"main": time.Now()}, 100)
+
+	freed, err := EvictCache(CacheLimits{}, false)
+	require.NoError(t, err)
+	require.Zero(t, freed)
+	require.FileExists(t, filepath.Join(dir, "aaa.tar.gz"))
+}
@@ -0,0 +1,60 @@
+package degit
+
+// Fetch resolves r and downloads its tarball into the cache without
+// extracting it anywhere, so a later Clone works offline. Subdir and file
+// mode are ignored: the whole tarball is cached, and with Trees its
+// extracted tree too. With Refresh a cached tarball is downloaded again.
+// It reports whether the tarball had to be downloaded.
+func (r *Repo) Fetch(verbose bool) (bool, error) {
+	if err := r.Resolve(); err != nil {
+		return false, err
+	}
+	downloaded, err := r.fetch(verbose)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := evictCache(cacheLimits, r.getRepoDir(), r.Hash, verbose); err != nil {
+		log(verbose, "could not enforce cache limits:", err)
+	}
+	return downloaded, nil
+}
+
+// fetch downloads the tarball for r.Hash unless it is cached, while
+// holding the lock on the repository cache directory.
+func (r *Repo) fetch(verbose bool) (bool, error) {
+	unlock, err := lockDir(r.getRepoDir())
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	file := r.getOutputFile(r.Hash)
+	var cached bool
+	if !r.Refresh {
+		cached, err = exists(file)
+		if err == nil && !cached {
+			cached, err = r.adoptObject(verbose)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	var f *fetched
+	if cached {
+		log(verbose, "already cached", r.URL)
+	} else if f, err = r.downloadTo(file, r.archiveURL(r.Hash), nil, verbose); err != nil {
+		return false, err
+	}
+	// A prefetch is not a clone and isn't counted in the cache stats.
+	if err := r.recordEntry(file, KindTarball, "", f, false, verbose); err != nil {
+		return false, err
+	}
+	if r.trees() {
+		if ok, err := exists(r.getTreeDir(r.Hash)); err == nil && (!ok || r.Refresh) {
+			r.buildTree(verbose)
+		}
+	}
+	return !cached, nil
+}
@@ -0,0 +1,34 @@
+package degit
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchCachesWithoutExtracting(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	archive := writeTarGz(t, []tarEntry{{name: "r-abc/README.md", content: "hello"}})
+	repo := newTestRepo(serveArchive(t, archive).URL, nil)
+	repo.Ref, repo.Hash = "main", "abc"
+
+	downloaded, err := repo.Fetch(false)
+	require.NoError(t, err)
+	require.True(t, downloaded)
+
+	want, err := os.ReadFile(archive)
+	require.NoError(t, err)
+	got, err := os.ReadFile(repo.getOutputFile("abc"))
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	entries, err := ListCache("")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "main", entries[0].Ref)
+
+	downloaded, err = repo.Fetch(false)
+	require.NoError(t, err)
+	require.False(t, downloaded, "a cached tarball is not downloaded again")
+}
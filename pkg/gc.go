@@ -0,0 +1,199 @@
+package degit
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// staleImportAge is how old an import staging directory must be before
+// CollectGarbage removes it; younger ones may belong to a running import.
+var staleImportAge = time.Hour
+
+// errDryRun aborts an index update without saving it.
+var errDryRun = errors.New("dry run")
+
+// GCResult reports what CollectGarbage repaired, or would repair on a dry
+// run. Paths are relative to the cache dir.
+type GCResult struct {
+	Missing []CacheEntry // index entries whose cached data was gone
+	Orphans []string     // cached data no index entry refers to
+	Temp    []string     // temporary and partial files left behind
+	Freed   int64        // bytes deleted
+}
+
+// CollectGarbage brings the cache and its index back in line: it drops
+// index entries whose data no longer exists, deletes tarballs, trees and
+// stored objects no entry refers to, and removes temporary files left by
+// interrupted downloads, tree builds and imports.
+func CollectGarbage(dryRun, verbose bool) (*GCResult, error) {
+	base := GetCacheDir()
+	result := &GCResult{}
+	if ok, err := exists(base); err != nil || !ok {
+		return result, err
+	}
+
+	dirs, err := repoDirs(base)
+	if err != nil {
+		return result, err
+	}
+	for _, dir := range dirs {
+		if err := collectRepo(dir.path, result, dryRun, verbose); err != nil {
+			return result, err
+		}
+	}
+
+	err = updateIndex(func(idx *Index) error {
+		objects := map[string]bool{}
+		for _, e := range idx.Entries {
+			if e.Object != "" {
+				objects[e.Object] = true
+			}
+		}
+		matches, err := filepath.Glob(path.Join(base, objectDirName, "*", "*"))
+		if err != nil {
+			return err
+		}
+		for _, m := range matches {
+			rel := relToCache(base, m)
+			if !objects[rel] {
+				result.Orphans = append(result.Orphans, rel)
+				result.Freed += removeGarbage(m, dryRun, verbose, removeObject)
+			}
+		}
+
+		for _, name := range []string{indexName + ".tmp", statsName + ".tmp"} {
+			if p := path.Join(base, name); isPresent(p) {
+				result.Temp = append(result.Temp, name)
+				result.Freed += removeGarbage(p, dryRun, verbose, nil)
+			}
+		}
+		imports, err := filepath.Glob(path.Join(base, ".import-*"))
+		if err != nil {
+			return err
+		}
+		for _, m := range imports {
+			if info, err := os.Stat(m); err == nil && time.Since(info.ModTime()) > staleImportAge {
+				result.Temp = append(result.Temp, relToCache(base, m))
+				result.Freed += removeGarbage(m, dryRun, verbose, nil)
+			}
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err == errDryRun {
+		err = nil
+	}
+	return result, err
+}
+
+// collectRepo repairs the repository cached in dir under its lock: entries
+// with missing data are dropped, then data without entries and partial
+// files are deleted.
+func collectRepo(dir string, result *GCResult, dryRun, verbose bool) error {
+	unlock, err := lockDir(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	base := GetCacheDir()
+	err = updateIndex(func(idx *Index) error {
+		inDir := func(e *CacheEntry) bool {
+			return path.Join(base, e.Site, e.User, e.Name) == dir
+		}
+		var missing []*CacheEntry
+		idx.Entries, missing = partition(idx.Entries, func(e *CacheEntry) bool {
+			return inDir(e) && !isPresent(path.Join(base, e.File))
+		})
+		for _, e := range missing {
+			log(verbose, "dropping index entry of missing", path.Join(base, e.File))
+			result.Missing = append(result.Missing, *e)
+		}
+
+		referenced := map[string]bool{}
+		for _, e := range idx.Entries {
+			if inDir(e) {
+				referenced[e.File] = true
+			}
+		}
+		err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err != nil || p == dir || info.Name() == lockFileName {
+				return err
+			}
+			rel := relToCache(base, p)
+			inRepo := relToCache(dir, p)
+			switch {
+			case strings.HasSuffix(p, ".part") || strings.HasSuffix(p, ".link"):
+				result.Temp = append(result.Temp, rel)
+			case referenced[rel]:
+			case info.IsDir() && path.Dir(inRepo) != treeDirName:
+				// A directory holding blobs or trees; look inside.
+				return nil
+			default:
+				result.Orphans = append(result.Orphans, rel)
+			}
+			if !referenced[rel] {
+				result.Freed += removeGarbage(p, dryRun, verbose, removeCached)
+			}
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		// Objects only the missing entries linked to are orphans now.
+		for _, e := range missing {
+			if e.Object != "" {
+				idx.removeObjectIfUnused(e.Object)
+			}
+		}
+		return nil
+	})
+	if err == errDryRun {
+		err = nil
+	}
+	return err
+}
+
+// removeGarbage deletes the file or directory p, unless dryRun is set, and
+// returns its size. remove, if non-nil, deletes p by its path relative to
+// the cache dir, cleaning up directories left empty.
+func removeGarbage(p string, dryRun, verbose bool, remove func(rel string)) int64 {
+	size, _ := dirSize(p)
+	if dryRun {
+		return size
+	}
+	log(verbose, "removing", p)
+	if remove != nil {
+		remove(relToCache(GetCacheDir(), p))
+	} else {
+		os.RemoveAll(p)
+	}
+	return size
+}
+
+func relToCache(base, p string) string {
+	rel, err := filepath.Rel(base, p)
+	if err != nil {
+		return p
+	}
+	return filepath.ToSlash(rel)
+}
+
+func isPresent(p string) bool {
+	ok, err := exists(p)
+	return ok || err != nil
+}
@@ -0,0 +1,84 @@
+package degit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	base := GetCacheDir()
+	dir := seedRepoCache(t, "github/u/r", map[string]string{"main": "aaa", "v1": "bbb"}, nil, 10)
+	entries, err := ListCache("")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	write := func(rel string, size int) {
+		p := filepath.Join(base, filepath.FromSlash(rel))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, make([]byte, size), 0o644))
+	}
+	require.NoError(t, os.Remove(filepath.Join(dir, "bbb.tar.gz")))
+	write("github/u/r/zzz.tar.gz", 5)
+	write("github/u/r/aaa.tar.gz.part", 3)
+	write("github/u/r/trees/old/README.md", 2)
+	write("objects/ccc/0123.tar.gz", 7)
+	write(indexName+".tmp", 1)
+
+	result, err := CollectGarbage(true, false)
+	require.NoError(t, err)
+	require.Len(t, result.Missing, 1)
+	require.Equal(t, "v1", result.Missing[0].Ref)
+	require.ElementsMatch(t, []string{"github/u/r/trees/old", "github/u/r/zzz.tar.gz", "objects/ccc/0123.tar.gz"}, result.Orphans)
+	require.ElementsMatch(t, []string{"github/u/r/aaa.tar.gz.part", indexName + ".tmp"}, result.Temp)
+	require.Equal(t, int64(18), result.Freed)
+	require.FileExists(t, filepath.Join(dir, "zzz.tar.gz"))
+	entries, err = ListCache("")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	result, err = CollectGarbage(false, false)
+	require.NoError(t, err)
+	require.Len(t, result.Missing, 1)
+	require.Len(t, result.Orphans, 3)
+	// Saving the index replaces its stray temporary file on the way.
+	require.Contains(t, result.Temp, "github/u/r/aaa.tar.gz.part")
+
+	require.FileExists(t, filepath.Join(dir, "aaa.tar.gz"))
+	require.NoFileExists(t, filepath.Join(dir, "zzz.tar.gz"))
+	require.NoFileExists(t, filepath.Join(dir, "aaa.tar.gz.part"))
+	require.NoDirExists(t, filepath.Join(dir, "trees"))
+	require.NoDirExists(t, filepath.Join(base, "objects", "ccc"))
+	require.NoFileExists(t, filepath.Join(base, indexName+".tmp"))
+	entries, err = ListCache("")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "main", entries[0].Ref)
+
+	result, err = CollectGarbage(false, false)
+	require.NoError(t, err)
+	require.Empty(t, result.Missing)
+	require.Empty(t, result.Orphans)
+	require.Empty(t, result.Temp)
+}
+
+func TestCollectGarbageKeepsRecentImports(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	base := GetCacheDir()
+	recent := filepath.Join(base, ".import-1")
+	stale := filepath.Join(base, ".import-2")
+	require.NoError(t, os.MkdirAll(recent, 0o755))
+	require.NoError(t, os.MkdirAll(stale, 0o755))
+	old := time.Now().Add(-2 * staleImportAge)
+	require.NoError(t, os.Chtimes(stale, old, old))
+
+	result, err := CollectGarbage(false, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{".import-2"}, result.Temp)
+	require.DirExists(t, recent)
+	require.NoDirExists(t, stale)
+}
@@ -0,0 +1,631 @@
+package degit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// indexVersion is the format version of index.json. Bump it whenever the
+// layout changes in a way older releases can't read.
+const indexVersion = 1
+
+var indexName = "index.json"
+
+// Kinds of cached data recorded in the index.
+const (
+	KindTarball = "tarball" // the host's archive of the whole repository
+	KindSubdir  = "subdir"  // a subdir-only archive built by a sparse fetch
+	KindFile    = "file"    // a single file fetched from a raw endpoint
+	KindTree    = "tree"    // an extracted tree, see Repo.Trees
+)
+
+// Index is the cache-wide metadata stored in index.json at the root of the
+// cache directory. It replaces the per-repository map.json and access.json
+// of earlier releases, which are migrated into it on first use.
+type Index struct {
+	Version int           `json:"version"`
+	Entries []*CacheEntry `json:"entries"`
+}
+
+// CacheEntry records that Ref of a repository resolved to Hash, and that
+// File holds data cached for it. A file may be shared by several refs, and
+// a ref may have entries for several hashes: its current one and the
+// earlier ones kept by the cache retention.
+type CacheEntry struct {
+	Site       string    `json:"site"`
+	User       string    `json:"user"`
+	Name       string    `json:"name"`
+	Ref        string    `json:"ref"`
+	Hash       string    `json:"hash"`
+	Kind       string    `json:"kind"`           // KindTarball, KindSubdir, KindFile or KindTree
+	Path       string    `json:"path,omitempty"` // subdirectory or file within the repository
+	File       string    `json:"file"`           // location of the cached data, relative to the cache dir
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256"`
+	FetchedAt  time.Time `json:"fetched_at"`
+	LastAccess time.Time `json:"last_access"`
+	Source     string    `json:"source"` // URL the data was downloaded from
+	Host       string    `json:"host"`
+	Pinned     bool      `json:"pinned,omitempty"` // never evicted, pruned or cleared, see PinCache
+	Object     string    `json:"object,omitempty"` // content-addressed tarball File links to, see storeObject
+}
+
+// Repo returns the entry's repository as "site/user/name".
+func (e CacheEntry) Repo() string {
+	return path.Join(e.Site, e.User, e.Name)
+}
+
+// isRepo reports whether e belongs to the repository of r.
+func (e *CacheEntry) isRepo(r *Repo) bool {
+	return e.Site == r.Site && e.User == r.User && e.Name == r.Name
+}
+
+// isRef reports whether e is cached for the ref of r, which may also be a
+// commit hash of at least 7 characters.
+func (e *CacheEntry) isRef(r *Repo) bool {
+	if !e.isRepo(r) {
+		return false
+	}
+	return e.Ref == r.Ref || (len(r.Ref) >= 7 && strings.HasPrefix(e.Hash, r.Ref))
+}
+
+// loadIndex reads the index. index.json is only ever replaced by a rename,
+// so an intact one is read without the lock and without writing, which
+// also works on a read-only cache. A missing or damaged one is migrated or
+// rebuilt under the cache-wide lock and saved. An absent cache yields an
+// empty index.
+func loadIndex() (*Index, error) {
+	base := GetCacheDir()
+	if ok, err := exists(base); err != nil || !ok {
+		return &Index{Version: indexVersion}, err
+	}
+	idx, err := decodeIndex(base)
+	if !os.IsNotExist(err) && err != errCorruptIndex {
+		return idx, err
+	}
+	err = updateIndex(func(i *Index) error {
+		idx = i
+		return nil
+	})
+	return idx, err
+}
+
+// updateIndex loads the index under the cache-wide lock, lets fn modify it
+// and saves the result. Callers that also lock a repository directory must
+// take that lock first.
+func updateIndex(fn func(*Index) error) error {
+	base := GetCacheDir()
+	unlock, err := lockDir(base)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	idx, err := readIndex(base)
+	if err != nil {
+		return err
+	}
+	if err := fn(idx); err != nil {
+		return err
+	}
+	return writeIndex(base, idx)
+}
+
+// readIndex reads index.json from the cache dir base. When it is missing,
+// the legacy per-repository logs are migrated; when it can't be parsed, it
+// is rebuilt from the data found on disk.
+func readIndex(base string) (*Index, error) {
+	idx, err := decodeIndex(base)
+	if os.IsNotExist(err) {
+		return migrateIndex(base)
+	}
+	if err == errCorruptIndex {
+		return rebuildIndex(base)
+	}
+	return idx, err
+}
+
+var errCorruptIndex = errors.New("corrupt cache index")
+
+// decodeIndex reads index.json from the cache dir base as it is, failing
+// with errCorruptIndex when it can't be parsed.
+func decodeIndex(base string) (*Index, error) {
+	b, err := os.ReadFile(path.Join(base, indexName))
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &Index{}
+	if err := json.Unmarshal(b, idx); err != nil || idx.Version < 1 {
+		return nil, errCorruptIndex
+	}
+	if idx.Version > indexVersion {
+		return nil, fmt.Errorf("cache index version %d is newer than this degit supports (%d), please upgrade", idx.Version, indexVersion)
+	}
+	idx.Entries = validEntries(idx.Entries)
+	return idx, nil
+}
+
+// validEntries drops entries that are too damaged to be used.
+func validEntries(entries []*CacheEntry) []*CacheEntry {
+	valid := entries[:0]
+	for _, e := range entries {
+		if e != nil && e.Site != "" && e.User != "" && e.Name != "" && e.Hash != "" && e.File != "" {
+			valid = append(valid, e)
+		}
+	}
+	return valid
+}
+
+func writeIndex(base string, idx *Index) error {
+	idx.Version = indexVersion
+	sort.SliceStable(idx.Entries, func(i, j int) bool {
+		a, b := idx.Entries[i], idx.Entries[j]
+		if a.Repo() != b.Repo() {
+			return a.Repo() < b.Repo()
+		}
+		if a.Ref != b.Ref {
+			return a.Ref < b.Ref
+		}
+		// The hash history of a ref, most recently used first.
+		if !a.LastAccess.Equal(b.LastAccess) {
+			return a.LastAccess.After(b.LastAccess)
+		}
+		return a.File < b.File
+	})
+
+	b, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path.Join(base, indexName+".tmp")
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path.Join(base, indexName))
+}
+
+// migrateIndex builds the index from the map.json (ref → hash) and
+// access.json (ref → last access) files of earlier releases, then removes
+// them.
+func migrateIndex(base string) (*Index, error) {
+	idx := &Index{Version: indexVersion}
+	dirs, err := repoDirs(base)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dir := range dirs {
+		hashes, err := readLog(path.Join(dir.path, hashLogName))
+		if err != nil {
+			// A damaged log can't be trusted; fall back to what's on disk.
+			return rebuildIndex(base)
+		}
+		access, err := readLog(path.Join(dir.path, accessLogName))
+		if err != nil {
+			return rebuildIndex(base)
+		}
+
+		for ref, hash := range hashes {
+			var lastAccess time.Time
+			if s, ok := access[ref].(string); ok {
+				lastAccess, _ = time.Parse(time.RFC3339Nano, s)
+			}
+			entries, err := scanHash(base, dir, ref, fmt.Sprint(hash), lastAccess)
+			if err != nil {
+				return nil, err
+			}
+			idx.Entries = append(idx.Entries, entries...)
+		}
+	}
+
+	for _, dir := range dirs {
+		os.Remove(path.Join(dir.path, hashLogName))
+		os.Remove(path.Join(dir.path, accessLogName))
+	}
+	return idx, nil
+}
+
+// rebuildIndex recreates the index from the cached data on disk. Which
+// refs resolved to a hash is lost, so every hash is recorded under itself
+// as ref, which is what cloning "repo#hash" looks up.
+func rebuildIndex(base string) (*Index, error) {
+	idx := &Index{Version: indexVersion}
+	dirs, err := repoDirs(base)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dir := range dirs {
+		files, err := os.ReadDir(dir.path)
+		if err != nil {
+			return nil, err
+		}
+		hashes := map[string]bool{}
+		for _, f := range files {
+			name := f.Name()
+			switch {
+			case (name == blobDirName || name == treeDirName) && f.IsDir():
+				blobs, err := os.ReadDir(path.Join(dir.path, name))
+				if err != nil {
+					return nil, err
+				}
+				for _, b := range blobs {
+					if !strings.HasSuffix(b.Name(), ".part") {
+						hashes[b.Name()] = true
+					}
+				}
+			case strings.HasSuffix(name, ".tar.gz"):
+				hash, _, _ := strings.Cut(strings.TrimSuffix(name, ".tar.gz"), "_")
+				hashes[hash] = true
+			}
+		}
+
+		for hash := range hashes {
+			entries, err := scanHash(base, dir, hash, hash, time.Time{})
+			if err != nil {
+				return nil, err
+			}
+			idx.Entries = append(idx.Entries, entries...)
+		}
+	}
+	return idx, nil
+}
+
+// scanHash creates entries for ref from the data cached on disk for hash in
+// the repository directory dir: its tarball, extracted tree, subdir
+// archives and files.
+func scanHash(base string, dir repoDir, ref string, hash string, lastAccess time.Time) ([]*CacheEntry, error) {
+	repo := &Repo{Site: dir.site, User: dir.user, Name: dir.name}
+	if parsed, err := ParseRepo(fmt.Sprintf("%s:%s/%s", dir.site, dir.user, dir.name)); err == nil {
+		repo = parsed
+	}
+
+	var entries []*CacheEntry
+	add := func(file, kind, p, source string) error {
+		info, err := os.Stat(file)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Extracted trees are directories, sized but not checksummed.
+		var sum string
+		size := info.Size()
+		if kind == KindTree {
+			size, err = dirSize(file)
+		} else {
+			sum, err = sha256File(file)
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, file)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, &CacheEntry{
+			Site: dir.site, User: dir.user, Name: dir.name,
+			Ref: ref, Hash: hash, Kind: kind, Path: p,
+			File:       filepath.ToSlash(rel),
+			Size:       size,
+			SHA256:     sum,
+			FetchedAt:  info.ModTime(),
+			LastAccess: lastAccess,
+			Source:     source,
+			Host:       hostOf(source),
+		})
+		return nil
+	}
+
+	if err := add(path.Join(dir.path, hash+".tar.gz"), KindTarball, "", repo.archiveURL(hash)); err != nil {
+		return nil, err
+	}
+
+	tree := path.Join(dir.path, treeDirName, hash)
+	if err := add(tree, KindTree, "", "file://"+path.Join(dir.path, hash+".tar.gz")); err != nil {
+		return nil, err
+	}
+
+	subdirs, err := filepath.Glob(path.Join(dir.path, hash+"_*.tar.gz"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range subdirs {
+		escaped := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), hash+"_"), ".tar.gz")
+		subdir, err := url.PathUnescape(escaped)
+		if err != nil {
+			continue
+		}
+		if err := add(file, KindSubdir, "/"+subdir, repo.URL); err != nil {
+			return nil, err
+		}
+	}
+
+	blobs := path.Join(dir.path, blobDirName, hash)
+	err = filepath.Walk(blobs, func(file string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(blobs, file)
+		if err != nil {
+			return err
+		}
+		r := *repo
+		r.Subdir = "/" + filepath.ToSlash(rel)
+		return add(file, KindFile, r.Subdir, r.rawURL(hash))
+	})
+	return entries, err
+}
+
+// repoDir is a site/user/name directory of the cache.
+type repoDir struct {
+	site, user, name string
+	path             string
+}
+
+// repoDirs lists the site/user/name directories of the cache dir base.
+func repoDirs(base string) ([]repoDir, error) {
+	matches, err := filepath.Glob(path.Join(base, "*", "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+	var dirs []repoDir
+	for _, m := range matches {
+		if info, err := os.Stat(m); err != nil || !info.IsDir() {
+			continue
+		}
+		rel, err := filepath.Rel(base, m)
+		if err != nil {
+			return nil, err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if strings.HasPrefix(parts[0], ".") {
+			// Not a site: e.g. the staging directory of an import.
+			continue
+		}
+		dirs = append(dirs, repoDir{site: parts[0], user: parts[1], name: parts[2], path: m})
+	}
+	return dirs, nil
+}
+
+// readLog reads one of the legacy per-repository JSON logs. A missing or
+// empty file yields an empty map.
+func readLog(p string) (map[string]any, error) {
+	data := make(map[string]any)
+	s, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(s) != 0 {
+		if err := json.Unmarshal(s, &data); err != nil {
+			return nil, fmt.Errorf("invalid cache log %s: %w", p, err)
+		}
+	}
+	return data, nil
+}
+
+// record notes in the index that r.Ref resolved to r.Hash and that the
+// clone used file, the absolute path of cached data of the given kind for
+// the repository path p. f describes the download that just produced file,
+// or is nil on a cache hit. Earlier, unpinned hashes of the ref beyond the
+// cache retention are dropped, and their data deleted once no other ref
+// uses it. The clone is counted in the cache stats.
+func (r *Repo) record(file, kind, p string, f *fetched, verbose bool) error {
+	return r.recordEntry(file, kind, p, f, true, verbose)
+}
+
+// recordEntry is record, counting the use in the cache stats only when
+// count is set.
+func (r *Repo) recordEntry(file, kind, p string, f *fetched, count bool, verbose bool) error {
+	rel, err := filepath.Rel(GetCacheDir(), file)
+	if err != nil {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+
+	return updateIndex(func(idx *Index) error {
+		now := time.Now()
+
+		var entry *CacheEntry
+		for _, e := range idx.Entries {
+			if e.isRepo(r) && e.Ref == r.Ref && e.File == rel {
+				entry = e
+			}
+		}
+		if entry == nil {
+			entry = &CacheEntry{
+				Site: r.Site, User: r.User, Name: r.Name,
+				Ref: r.Ref, Hash: r.Hash, Kind: kind, Path: p, File: rel,
+			}
+			// Data already known under another ref shares its metadata.
+			for _, e := range idx.Entries {
+				if e.File == rel {
+					entry.Size, entry.SHA256, entry.FetchedAt = e.Size, e.SHA256, e.FetchedAt
+					entry.Source, entry.Host, entry.Object = e.Source, e.Host, e.Object
+					break
+				}
+			}
+			idx.Entries = append(idx.Entries, entry)
+		}
+
+		var replaced []string
+		if f != nil {
+			// A download replaces the file, and with it the object the
+			// file linked to.
+			for _, e := range idx.Entries {
+				if e.File == rel {
+					if e.Object != "" {
+						replaced = append(replaced, e.Object)
+					}
+					e.Size, e.SHA256, e.FetchedAt = f.size, f.sha256, now
+					e.Source, e.Host, e.Object = f.url, hostOf(f.url), ""
+				}
+			}
+		} else if entry.SHA256 == "" && kind != KindTree {
+			info, err := os.Stat(file)
+			if err != nil {
+				return err
+			}
+			if entry.SHA256, err = sha256File(file); err != nil {
+				return err
+			}
+			entry.Size, entry.FetchedAt = info.Size(), info.ModTime()
+		}
+		entry.LastAccess = now
+
+		if kind == KindTarball && entry.Object == "" {
+			if err := idx.linkObject(rel, r.Hash, entry.SHA256); err != nil {
+				return err
+			}
+		}
+		for _, obj := range replaced {
+			idx.removeObjectIfUnused(obj)
+		}
+
+		if count {
+			r.countUse(f, entry.Size, verbose)
+		}
+
+		expired := idx.expired(r, r.Ref, cacheRetention, now)
+		var outdated []*CacheEntry
+		idx.Entries, outdated = partition(idx.Entries, func(e *CacheEntry) bool {
+			return e.isRepo(r) && e.Ref == r.Ref && expired[e.Hash] && !e.Pinned
+		})
+		for _, e := range outdated {
+			log(verbose, "removing outdated cache", path.Join(GetCacheDir(), e.File))
+		}
+		idx.removeUnreferenced(outdated)
+		return nil
+	})
+}
+
+// linkObject stores the tarball at rel, relative to the cache dir, as the
+// object for commit hash and points the entries of rel at it. The caller
+// holds the index lock.
+func (idx *Index) linkObject(rel, hash, sum string) error {
+	obj, err := storeObject(path.Join(GetCacheDir(), rel), hash, sum)
+	if err != nil {
+		return err
+	}
+	for _, e := range idx.Entries {
+		if e.File == rel {
+			e.Object = obj
+		}
+	}
+	return nil
+}
+
+// partition splits entries into those drop rejects and those it selects.
+func partition(entries []*CacheEntry, drop func(*CacheEntry) bool) (kept, dropped []*CacheEntry) {
+	for _, e := range entries {
+		if drop(e) {
+			dropped = append(dropped, e)
+		} else {
+			kept = append(kept, e)
+		}
+	}
+	return kept, dropped
+}
+
+// unreferenced returns one of the dropped entries for each file no entry
+// left in the index refers to.
+func (idx *Index) unreferenced(dropped []*CacheEntry) []*CacheEntry {
+	referenced := map[string]bool{}
+	for _, e := range idx.Entries {
+		referenced[e.File] = true
+	}
+
+	var orphans []*CacheEntry
+	for _, e := range dropped {
+		if !referenced[e.File] {
+			referenced[e.File] = true
+			orphans = append(orphans, e)
+		}
+	}
+	return orphans
+}
+
+// sizeUnreferenced returns the bytes removeUnreferenced would free.
+func (idx *Index) sizeUnreferenced(dropped []*CacheEntry) int64 {
+	var size int64
+	for _, e := range idx.unreferenced(dropped) {
+		size += e.Size
+	}
+	return size
+}
+
+// removeUnreferenced deletes the data of the dropped entries that no entry
+// left in the index refers to, and returns the number of bytes freed.
+func (idx *Index) removeUnreferenced(dropped []*CacheEntry) int64 {
+	for _, e := range idx.unreferenced(dropped) {
+		removeCached(e.File)
+		if e.Object != "" {
+			idx.removeObjectIfUnused(e.Object)
+		}
+	}
+	return idx.sizeUnreferenced(dropped)
+}
+
+// removeObjectIfUnused deletes the stored tarball obj once no entry links
+// to it anymore.
+func (idx *Index) removeObjectIfUnused(obj string) {
+	for _, e := range idx.Entries {
+		if e.Object == obj {
+			return
+		}
+	}
+	removeObject(obj)
+}
+
+// removeCached deletes the cached data at rel, relative to the cache dir,
+// along with directories left empty below the repository directory.
+func removeCached(rel string) {
+	base := GetCacheDir()
+	os.RemoveAll(path.Join(base, rel))
+	for dir := path.Dir(rel); strings.Count(dir, "/") > 2; dir = path.Dir(dir) {
+		if os.Remove(path.Join(base, dir)) != nil {
+			break
+		}
+	}
+}
+
+func sha256File(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
@@ -0,0 +1,126 @@
+package degit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readIndexFile(t *testing.T) *Index {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(GetCacheDir(), indexName))
+	require.NoError(t, err)
+	idx := &Index{}
+	require.NoError(t, json.Unmarshal(b, idx))
+	return idx
+}
+
+func TestIndexMigratesLegacyLogs(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	dir := seedRepoCache(t, "github/u/r",
+		map[string]string{"main": "aaa"},
+		map[string]time.Time{"main": time.Now()}, 10)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "aaa_src.tar.gz"), make([]byte, 3), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, blobDirName, "aaa", "docs"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, blobDirName, "aaa", "docs", "a.md"), []byte("hi"), 0o644))
+
+	entries, err := ListCache("")
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	kinds := map[string]string{}
+	for _, e := range entries {
+		kinds[e.Kind] = e.Path
+	}
+	require.Equal(t, map[string]string{KindTarball: "", KindSubdir: "/src", KindFile: "/docs/a.md"}, kinds)
+
+	require.NoFileExists(t, filepath.Join(dir, hashLogName))
+	require.NoFileExists(t, filepath.Join(dir, accessLogName))
+	require.Equal(t, indexVersion, readIndexFile(t).Version)
+}
+
+func TestIndexRebuildsWhenCorrupt(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	dir := filepath.Join(GetCacheDir(), "github", "u", "r")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "aaa.tar.gz"), make([]byte, 10), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(GetCacheDir(), indexName), []byte("{not json"), 0o644))
+
+	entries, err := ListCache("")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "aaa", entries[0].Ref, "a rebuilt entry is keyed by its hash")
+	require.Equal(t, "github/u/r/aaa.tar.gz", entries[0].File)
+}
+
+func TestLoadIndexDoesNotWrite(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	repo, err := ParseRepo("u/r#main")
+	require.NoError(t, err)
+	repo.Hash = "aaa"
+	file := repo.getOutputFile("aaa")
+	require.NoError(t, os.MkdirAll(filepath.Dir(file), 0o755))
+	require.NoError(t, os.WriteFile(file, []byte("aaa"), 0o644))
+	require.NoError(t, repo.record(file, KindTarball, "", nil, false))
+	index := filepath.Join(GetCacheDir(), indexName)
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, os.Chtimes(index, old, old))
+	require.NoError(t, os.Remove(filepath.Join(GetCacheDir(), lockFileName)))
+
+	entries, err := ListCache("")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	info, err := os.Stat(index)
+	require.NoError(t, err)
+	require.True(t, info.ModTime().Equal(old), "reading the index must not rewrite it")
+	require.NoFileExists(t, filepath.Join(GetCacheDir(), lockFileName), "reading the index must not lock it")
+}
+
+func TestIndexRejectsNewerVersion(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	require.NoError(t, os.WriteFile(filepath.Join(GetCacheDir(), indexName), []byte(`{"version": 99}`), 0o644))
+
+	_, err := ListCache("")
+	require.ErrorContains(t, err, "newer")
+}
+
+func TestRecordReplacesOutdatedHash(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	SetCacheRetention(CacheRetention{Hashes: 1})
+	defer SetCacheRetention(DefaultCacheRetention)
+	repo, err := ParseRepo("github:u/r#main")
+	require.NoError(t, err)
+
+	write := func(hash string) string {
+		file := repo.getOutputFile(hash)
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0o755))
+		require.NoError(t, os.WriteFile(file, []byte(hash), 0o644))
+		return file
+	}
+
+	repo.Hash = "aaa"
+	old := write("aaa")
+	require.NoError(t, repo.record(old, KindTarball, "", &fetched{url: "https://mirror.example.com/a.tar.gz", size: 3, sha256: "x"}, false))
+
+	entries, err := ListCache("")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "mirror.example.com", entries[0].Host)
+	require.Equal(t, int64(3), entries[0].Size)
+	require.False(t, entries[0].FetchedAt.IsZero())
+
+	repo.Hash = "bbb"
+	require.NoError(t, repo.record(write("bbb"), KindTarball, "", nil, false))
+
+	entries, err = ListCache("")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "bbb", entries[0].Hash)
+	require.Len(t, entries[0].SHA256, 64, "a cache hit without metadata hashes the file")
+	require.NoFileExists(t, old, "data no ref uses anymore is deleted")
+}
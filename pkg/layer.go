@@ -0,0 +1,71 @@
+package degit
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SharedCacheDirs returns the read-only cache layers listed in
+// $DEGIT_SHARED_CACHE, separated like $PATH. They share the layout of the
+// cache dir, e.g. a directory of pre-fetched tarballs on a network mount,
+// and are consulted in order when the user cache misses. degit never
+// writes to them.
+func SharedCacheDirs() []string {
+	var dirs []string
+	for _, dir := range filepath.SplitList(os.Getenv("DEGIT_SHARED_CACHE")) {
+		if dir != "" && dir != GetCacheDir() {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// findShared returns the first shared layer holding data for r.Hash, with
+// the path and kind of that data. layer is "" when no layer has it.
+func (r *Repo) findShared() (layer, file, kind string, err error) {
+	// Names within the repository directory of a layer.
+	type candidate struct{ name, kind string }
+	candidates := []candidate{{path.Base(r.getOutputFile(r.Hash)), KindTarball}}
+	if r.IsFile {
+		candidates = append(candidates, candidate{path.Join(blobDirName, r.Hash, strings.TrimPrefix(r.Subdir, "/")), KindFile})
+	}
+	if r.sparse() {
+		candidates = append(candidates, candidate{path.Base(r.getSubdirFile(r.Hash)), KindSubdir})
+	}
+
+	for _, dir := range SharedCacheDirs() {
+		for _, c := range candidates {
+			file := path.Join(dir, r.Site, r.User, r.Name, c.name)
+			ok, err := exists(file)
+			if err != nil {
+				return "", "", "", err
+			}
+			if ok {
+				return dir, file, c.kind, nil
+			}
+		}
+	}
+	return "", "", "", nil
+}
+
+// cloneShared fills dst from the shared layer r.CacheLayer.
+func (r *Repo) cloneShared(dst string, verbose bool) error {
+	_, file, kind, err := r.findShared()
+	if err != nil {
+		return err
+	}
+	log(verbose, "using shared cache", file)
+	if info, err := os.Stat(file); err == nil {
+		r.addUse(nil, info.Size(), verbose)
+	}
+	switch kind {
+	case KindFile:
+		return copyFile(file, dst)
+	case KindSubdir:
+		return untar(file, dst, r.Subdir, r.archivePrefix(), false)
+	default:
+		return untar(file, dst, r.Subdir, "", r.IsFile)
+	}
+}
@@ -0,0 +1,60 @@
+package degit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloneFromSharedCache(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	first, second := t.TempDir(), t.TempDir()
+	t.Setenv("DEGIT_SHARED_CACHE", first+string(filepath.ListSeparator)+second)
+
+	archive := writeTarGz(t, []tarEntry{
+		{name: "r-abc/", isDir: true},
+		{name: "r-abc/README.md", content: "shared"},
+	})
+	body, err := os.ReadFile(archive)
+	require.NoError(t, err)
+	dir := filepath.Join(second, "github", "u", "r")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "abc.tar.gz"), body, 0o644))
+
+	// The server must not be hit: the shared layer comes before the network.
+	repo := newTestRepo("http://127.0.0.1:0", nil)
+	repo.Hash = "abc"
+	require.NoError(t, repo.checkCache())
+	require.True(t, repo.Cached)
+	require.Equal(t, second, repo.CacheLayer)
+
+	dst := filepath.Join(t.TempDir(), "out")
+	require.NoError(t, repo.Clone(dst, false, false))
+	require.Equal(t, "shared", readFile(t, filepath.Join(dst, "README.md")))
+	require.NoFileExists(t, repo.getOutputFile("abc"), "shared hits are not copied into the user cache")
+
+	// The user cache is the top layer.
+	require.NoError(t, os.MkdirAll(repo.getRepoDir(), 0o755))
+	require.NoError(t, os.WriteFile(repo.getOutputFile("abc"), body, 0o644))
+	require.NoError(t, repo.checkCache())
+	require.True(t, repo.Cached)
+	require.Empty(t, repo.CacheLayer)
+}
+
+func TestSharedCacheWithRelativeCacheDir(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("DEGIT_CACHE_DIR", "./c")
+	shared := t.TempDir()
+	t.Setenv("DEGIT_SHARED_CACHE", shared)
+
+	dir := filepath.Join(shared, "github", "u", "r")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "abc.tar.gz"), []byte("x"), 0o644))
+
+	repo := newTestRepo("http://127.0.0.1:0", nil)
+	repo.Hash = "abc"
+	require.NoError(t, repo.checkCache())
+	require.Equal(t, shared, repo.CacheLayer)
+}
@@ -0,0 +1,142 @@
+package degit
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// ListCache returns the entries of the cache index in site/user/name, ref
+// and file order. If filter is non-empty it is parsed like a clone source
+// and only that repository is listed.
+func ListCache(filter string) ([]CacheEntry, error) {
+	var repo *Repo
+	if filter != "" {
+		r, err := ParseRepo(filter)
+		if err != nil {
+			return nil, err
+		}
+		repo = r
+	}
+
+	idx, err := loadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []CacheEntry
+	for _, e := range idx.Entries {
+		if repo == nil || e.isRepo(repo) {
+			entries = append(entries, *e)
+		}
+	}
+	return entries, nil
+}
+
+// CachedRepo summarizes the cached data of one repository.
+type CachedRepo struct {
+	Site, User, Name string
+	Size             int64     // bytes on disk, including extracted trees
+	Entries          int       // entries in the cache index
+	Pinned           int       // pinned entries, kept by ClearCache
+	LastAccess       time.Time // most recent use of any entry, zero if never
+}
+
+// Repo returns the repository as "site/user/name".
+func (c CachedRepo) Repo() string {
+	return path.Join(c.Site, c.User, c.Name)
+}
+
+// FindCachedRepos returns the cached repositories matching filter, in
+// site/user/name order. See ClearCache for the filter syntax.
+func FindCachedRepos(filter string) ([]CachedRepo, error) {
+	match, err := parseRepoFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	base := GetCacheDir()
+	if ok, err := exists(base); err != nil || !ok {
+		return nil, err
+	}
+
+	idx, err := loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := repoDirs(base)
+	if err != nil {
+		return nil, err
+	}
+
+	var repos []CachedRepo
+	for _, d := range dirs {
+		if !match(d.site, d.user, d.name) {
+			continue
+		}
+		size, err := dirSize(d.path)
+		if err != nil {
+			return nil, err
+		}
+		c := CachedRepo{Site: d.site, User: d.user, Name: d.name, Size: size}
+		for _, e := range idx.Entries {
+			if e.Site != d.site || e.User != d.user || e.Name != d.name {
+				continue
+			}
+			c.Entries++
+			if e.Pinned {
+				c.Pinned++
+			}
+			if e.LastAccess.After(c.LastAccess) {
+				c.LastAccess = e.LastAccess
+			}
+		}
+		if c.Entries == 0 && c.Size == 0 {
+			// Only the lock file is left, e.g. after ClearCache.
+			continue
+		}
+		repos = append(repos, c)
+	}
+	return repos, nil
+}
+
+// parseRepoFilter returns a matcher for the repositories selected by filter.
+// An empty filter matches everything. A filter with glob characters is read
+// as [site:]user/name with path.Match patterns in each part, the site
+// defaulting to github; anything else is parsed like a clone source.
+func parseRepoFilter(filter string) (func(site, user, name string) bool, error) {
+	if filter == "" {
+		return func(string, string, string) bool { return true }, nil
+	}
+	if !strings.ContainsAny(filter, "*?[") {
+		r, err := ParseRepo(filter)
+		if err != nil {
+			return nil, err
+		}
+		return func(site, user, name string) bool {
+			return site == r.Site && user == r.User && name == r.Name
+		}, nil
+	}
+
+	site, rest, ok := strings.Cut(filter, ":")
+	if !ok {
+		site, rest = "github", filter
+	}
+	site = strings.TrimSuffix(strings.TrimSuffix(site, ".com"), ".org")
+	user, name, ok := strings.Cut(rest, "/")
+	if !ok || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("invalid filter %q, e.g. github:myorg/* or gitlab:*/*", filter)
+	}
+	for _, p := range []string{site, user, name} {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid filter %q: %w", filter, err)
+		}
+	}
+	glob := func(pattern, s string) bool {
+		ok, _ := path.Match(pattern, s)
+		return ok
+	}
+	return func(s, u, n string) bool {
+		return glob(site, s) && glob(user, u) && glob(name, n)
+	}, nil
+}
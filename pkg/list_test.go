@@ -0,0 +1,137 @@
+package degit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// seedRepoCache writes a repository cache directory under the current cache
+// dir with a tarball of size bytes for every hash in refs, and the matching
+// legacy map.json and access.json, which are migrated into the index on the
+// first lookup.
+func seedRepoCache(t *testing.T, repo string, refs map[string]string, accessed map[string]time.Time, size int) string {
+	t.Helper()
+	dir := filepath.Join(GetCacheDir(), filepath.FromSlash(repo))
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	for _, hash := range refs {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, hash+".tar.gz"), make([]byte, size), 0o644))
+	}
+	write := func(name string, v any) {
+		b, err := json.Marshal(v)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), b, 0o644))
+	}
+	write(hashLogName, refs)
+	write(accessLogName, accessed)
+	return dir
+}
+
+func TestListCache(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	used := time.Date(2026, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	seedRepoCache(t, "github/u/r",
+		map[string]string{"main": "aaa", "v1": "bbb"},
+		map[string]time.Time{"main": used}, 10)
+	seedRepoCache(t, "gitlab/org/tpl",
+		map[string]string{"HEAD": "ccc"},
+		map[string]time.Time{"HEAD": used}, 20)
+
+	entries, err := ListCache("")
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	for _, e := range entries {
+		require.Equal(t, KindTarball, e.Kind)
+		require.Equal(t, e.Repo()+"/"+e.Hash+".tar.gz", e.File)
+		require.Len(t, e.SHA256, 64)
+	}
+	type key struct {
+		repo, ref, hash string
+		size            int64
+		used            time.Time
+	}
+	var got []key
+	for _, e := range entries {
+		got = append(got, key{e.Repo(), e.Ref, e.Hash, e.Size, e.LastAccess.UTC()})
+	}
+	require.Equal(t, []key{
+		{"github/u/r", "main", "aaa", 10, used},
+		{"github/u/r", "v1", "bbb", 10, time.Time{}},
+		{"gitlab/org/tpl", "HEAD", "ccc", 20, used},
+	}, got)
+	require.Equal(t, "https://github.com/u/r/archive/aaa.tar.gz", entries[0].Source)
+	require.Equal(t, "github.com", entries[0].Host)
+
+	entries, err = ListCache("gitlab:org/tpl")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "gitlab/org/tpl", entries[0].Repo())
+
+	entries, err = ListCache("github:nobody/nothing")
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestFindCachedRepos(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	used := time.Date(2026, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	seedRepoCache(t, "github/myorg/a", map[string]string{"main": "aaa", "v1": "bbb"},
+		map[string]time.Time{"main": used}, 10)
+	seedRepoCache(t, "github/myorg/b", map[string]string{"main": "ccc"}, nil, 20)
+	seedRepoCache(t, "github/other/c", map[string]string{"main": "ddd"}, nil, 30)
+	seedRepoCache(t, "gitlab/myorg/d", map[string]string{"main": "eee"}, nil, 40)
+
+	names := func(filter string) []string {
+		t.Helper()
+		repos, err := FindCachedRepos(filter)
+		require.NoError(t, err)
+		var got []string
+		for _, r := range repos {
+			got = append(got, r.Repo())
+		}
+		return got
+	}
+	require.Equal(t, []string{"github/myorg/a", "github/myorg/b", "github/other/c", "gitlab/myorg/d"}, names(""))
+	require.Equal(t, []string{"github/myorg/a", "github/myorg/b"}, names("github:myorg/*"))
+	require.Equal(t, []string{"github/myorg/a", "github/myorg/b"}, names("myorg/*"))
+	require.Equal(t, []string{"gitlab/myorg/d"}, names("gitlab:*/*"))
+	require.Equal(t, []string{"github/myorg/a", "gitlab/myorg/d"}, names("*:myorg/[ad]"))
+	require.Equal(t, []string{"github/other/c"}, names("other/c"))
+	require.Empty(t, names("bitbucket:*/*"))
+
+	repos, err := FindCachedRepos("myorg/a")
+	require.NoError(t, err)
+	require.Len(t, repos, 1)
+	require.Equal(t, 2, repos[0].Entries)
+	require.Equal(t, used, repos[0].LastAccess.UTC())
+	require.GreaterOrEqual(t, repos[0].Size, int64(20))
+
+	_, err = FindCachedRepos("github:*")
+	require.ErrorContains(t, err, "invalid filter")
+	_, err = FindCachedRepos("github:myorg/[")
+	require.ErrorContains(t, err, "invalid filter")
+}
+
+func TestClearCacheGlob(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	seedRepoCache(t, "github/myorg/a", map[string]string{"main": "aaa"}, nil, 10)
+	seedRepoCache(t, "github/myorg/b", map[string]string{"main": "bbb"}, nil, 10)
+	keep := seedRepoCache(t, "github/other/c", map[string]string{"main": "ccc"}, nil, 10)
+
+	_, err := ClearCache("github:myorg/*", false)
+	require.NoError(t, err)
+
+	requireCleared(t, filepath.Join(GetCacheDir(), "github", "myorg", "a"))
+	requireCleared(t, filepath.Join(GetCacheDir(), "github", "myorg", "b"))
+	require.FileExists(t, filepath.Join(keep, "ccc.tar.gz"))
+	entries, err := ListCache("")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "github/other/c", entries[0].Repo())
+}
@@ -0,0 +1,53 @@
+package degit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+var lockFileName = ".lock"
+
+// LockWaitOutput receives the message printed when another process holds
+// the lock on a repository cache directory. Set it to io.Discard to wait
+// silently.
+var LockWaitOutput io.Writer = os.Stderr
+
+// lockDir takes an exclusive advisory lock on the cache directory dir,
+// creating it if needed, and blocks until the lock is available. Parallel
+// degit processes serialize downloads, index updates and outdated-hash
+// removal for the same repository through it. The returned function
+// releases the lock.
+func lockDir(dir string) (func(), error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path.Join(dir, lockFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = lockFile(f, false)
+	if isLockBusy(err) {
+		name, relErr := filepath.Rel(GetCacheDir(), dir)
+		switch {
+		case relErr != nil:
+			name = dir
+		case name == ".":
+			name = "the cache index"
+		}
+		fmt.Fprintf(LockWaitOutput, "waiting for lock on %s, another degit process is using it\n", filepath.ToSlash(name))
+		err = lockFile(f, true)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not lock %s: %w", dir, err)
+	}
+
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
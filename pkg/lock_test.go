@@ -0,0 +1,66 @@
+package degit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a bytes.Buffer safe for the waiting goroutine to write to.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestLockDirWaitsForHolder(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("DEGIT_CACHE_DIR", cache)
+	dir := filepath.Join(cache, "github", "u", "r")
+
+	var out syncBuffer
+	LockWaitOutput = &out
+	defer func() { LockWaitOutput = os.Stderr }()
+
+	unlock, err := lockDir(dir)
+	require.NoError(t, err)
+
+	acquired := make(chan struct{})
+	go func() {
+		unlock, err := lockDir(dir)
+		if err == nil {
+			unlock()
+		}
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second lockDir must block while the lock is held")
+	case <-time.After(100 * time.Millisecond):
+	}
+	require.Equal(t, "waiting for lock on github/u/r, another degit process is using it\n", out.String())
+
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second lockDir should acquire the lock once it is released")
+	}
+}
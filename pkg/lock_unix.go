@@ -0,0 +1,35 @@
+//go:build !windows
+
+package degit
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// keepLockFiles leaves lock files in place when the cache is cleared. A
+// process blocked on a removed one would hold a lock on an unlinked file
+// while the next process locks a fresh one, and both would own the dir.
+const keepLockFiles = true
+
+func lockFile(f *os.File, block bool) error {
+	how := syscall.LOCK_EX
+	if !block {
+		how |= syscall.LOCK_NB
+	}
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if !errors.Is(err, syscall.EINTR) {
+			return err
+		}
+	}
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+func isLockBusy(err error) bool {
+	return errors.Is(err, syscall.EWOULDBLOCK)
+}
@@ -0,0 +1,93 @@
+package degit
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// objectDirName is the content-addressed store of tarballs, laid out as
+// objects/<commit hash>/<sha256>.tar.gz. The tarball paths of repository
+// cache dirs are hardlinks into it, so the same commit fetched through a
+// fork, a mirror or another host alias is stored once.
+var objectDirName = "objects"
+
+// storeObject moves the tarball at file, with the given checksum, into the
+// store as the object for commit hash and leaves a hardlink to it at file.
+// When the store already holds the same content, file is replaced by a link
+// to it. It returns the object path relative to the cache dir, or "" when
+// the file system doesn't support hardlinks and file stays a plain copy.
+// The caller holds the cache-wide index lock.
+func storeObject(file, hash, sum string) (string, error) {
+	rel := path.Join(objectDirName, hash, sum+".tar.gz")
+	obj := path.Join(GetCacheDir(), rel)
+	if err := os.MkdirAll(path.Dir(obj), os.ModePerm); err != nil {
+		return "", err
+	}
+
+	ok, err := exists(obj)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		if err := os.Link(file, obj); err != nil {
+			return "", nil
+		}
+		return rel, nil
+	}
+
+	if a, err := os.Stat(file); err == nil {
+		if b, err := os.Stat(obj); err == nil && os.SameFile(a, b) {
+			return rel, nil
+		}
+	}
+	tmp := file + ".link"
+	os.Remove(tmp)
+	if err := os.Link(obj, tmp); err != nil {
+		return "", nil
+	}
+	return rel, os.Rename(tmp, file)
+}
+
+// findObject returns a stored tarball of commit hash, fetched through any
+// fork or mirror of the repository, or "" when there is none.
+func findObject(hash string) (string, error) {
+	matches, err := filepath.Glob(path.Join(GetCacheDir(), objectDirName, hash, "*.tar.gz"))
+	if err != nil || len(matches) == 0 {
+		return "", err
+	}
+	return matches[0], nil
+}
+
+// adoptObject links a stored tarball of r.Hash, fetched for another fork or
+// mirror, into the repository cache dir and records it. It reports whether
+// there was one. The caller holds the lock on the repository cache dir.
+func (r *Repo) adoptObject(verbose bool) (bool, error) {
+	obj, err := findObject(r.Hash)
+	if err != nil || obj == "" {
+		return false, err
+	}
+	info, err := os.Stat(obj)
+	if err != nil {
+		return false, err
+	}
+
+	file := r.getOutputFile(r.Hash)
+	if err := os.Link(obj, file); err != nil {
+		if err := copyFile(obj, file); err != nil {
+			return false, err
+		}
+	}
+	log(verbose, "reusing tarball of the same commit from", obj)
+	sum := strings.TrimSuffix(path.Base(obj), ".tar.gz")
+	return true, r.record(file, KindTarball, "", &fetched{url: "file://" + obj, size: info.Size(), sha256: sum}, verbose)
+}
+
+// removeObject deletes the object at rel, relative to the cache dir, with
+// its commit directory once empty.
+func removeObject(rel string) {
+	obj := path.Join(GetCacheDir(), rel)
+	os.Remove(obj)
+	os.Remove(path.Dir(obj))
+}
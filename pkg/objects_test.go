@@ -0,0 +1,69 @@
+package degit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestForkCloneReusesStoredTarball(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	archive := writeTarGz(t, []tarEntry{
+		{name: "upstream-abc/", isDir: true},
+		{name: "upstream-abc/README.md", content: "hello"},
+	})
+
+	upstream := newTestRepo(serveArchive(t, archive).URL, nil)
+	upstream.Name, upstream.Ref, upstream.Hash = "upstream", "main", "abc"
+	require.NoError(t, upstream.Clone(filepath.Join(t.TempDir(), "a"), false, false))
+
+	// The fork's host is unreachable: only the stored object can serve it.
+	fork := newTestRepo("http://127.0.0.1:0", nil)
+	fork.User, fork.Name, fork.Ref, fork.Hash = "someone", "fork", "main", "abc"
+	require.NoError(t, fork.checkCache())
+	require.True(t, fork.Cached)
+
+	dst := filepath.Join(t.TempDir(), "b")
+	require.NoError(t, fork.Clone(dst, false, false))
+	require.Equal(t, "hello", readFile(t, filepath.Join(dst, "README.md")))
+
+	a, err := os.Stat(upstream.getOutputFile("abc"))
+	require.NoError(t, err)
+	b, err := os.Stat(fork.getOutputFile("abc"))
+	require.NoError(t, err)
+	require.True(t, os.SameFile(a, b), "both repositories link to one stored tarball")
+
+	objects, err := filepath.Glob(filepath.Join(GetCacheDir(), objectDirName, "abc", "*.tar.gz"))
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+
+	_, err = ClearCache("github:u/upstream", false)
+	require.NoError(t, err)
+	require.FileExists(t, objects[0], "the fork still uses the object")
+
+	_, err = ClearCache("github:someone/fork", false)
+	require.NoError(t, err)
+	require.NoFileExists(t, objects[0])
+}
+
+func TestStoreObjectDedupes(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "a.tar.gz"), filepath.Join(dir, "b.tar.gz")
+	require.NoError(t, os.WriteFile(first, []byte("same"), 0o644))
+	require.NoError(t, os.WriteFile(second, []byte("same"), 0o644))
+
+	obj, err := storeObject(first, "abc", "sum")
+	require.NoError(t, err)
+	require.Equal(t, "objects/abc/sum.tar.gz", obj)
+	_, err = storeObject(second, "abc", "sum")
+	require.NoError(t, err)
+
+	a, err := os.Stat(first)
+	require.NoError(t, err)
+	b, err := os.Stat(second)
+	require.NoError(t, err)
+	require.True(t, os.SameFile(a, b))
+}
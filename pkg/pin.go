@@ -0,0 +1,77 @@
+package degit
+
+import "fmt"
+
+// PinCache pins, or with pin false unpins, the cached data of a ref so it
+// is never evicted, pruned, cleared or dropped by the cache retention.
+// source is parsed like a clone source; its ref (HEAD when omitted) may
+// also be a commit hash of at least 7 characters. Pinning applies to the
+// most recently used hash of the ref, unpinning to all of them. It returns
+// the entries that changed.
+func PinCache(source string, pin bool) ([]CacheEntry, error) {
+	r, err := ParseRepo(source)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []CacheEntry
+	err = updateIndex(func(idx *Index) error {
+		hashes := map[string]bool{}
+		if history := idx.history(r, r.Ref); len(history) > 0 {
+			hashes[history[0].hash] = true
+			if !pin {
+				for _, h := range history {
+					hashes[h.hash] = true
+				}
+			}
+		}
+
+		for _, e := range idx.Entries {
+			if e.Pinned == pin || !e.isRef(r) || (e.Ref == r.Ref && !hashes[e.Hash]) {
+				continue
+			}
+			e.Pinned = pin
+			changed = append(changed, *e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(changed) == 0 && pin {
+		ok, err := isCached(r)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s is not cached, clone it first", source)
+		}
+	}
+	return changed, nil
+}
+
+// isCached reports whether the index has entries for the ref of r.
+func isCached(r *Repo) (bool, error) {
+	idx, err := loadIndex()
+	if err != nil {
+		return false, err
+	}
+	for _, e := range idx.Entries {
+		if e.isRef(r) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// pinnedFiles returns the files referenced by a pinned entry.
+func (idx *Index) pinnedFiles() map[string]bool {
+	pinned := map[string]bool{}
+	for _, e := range idx.Entries {
+		if e.Pinned {
+			pinned[e.File] = true
+		}
+	}
+	return pinned
+}
@@ -0,0 +1,71 @@
+package degit
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPinnedEntriesSurviveClear(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	now := time.Now()
+	dir := seedRepoCache(t, "github/u/r",
+		map[string]string{"main": "aaa", "v1": "bbb"},
+		map[string]time.Time{"main": now, "v1": now}, 10)
+	other := seedRepoCache(t, "github/u/other",
+		map[string]string{"main": "ccc"},
+		map[string]time.Time{"main": now}, 10)
+
+	pinned, err := PinCache("u/r#v1", true)
+	require.NoError(t, err)
+	require.Len(t, pinned, 1)
+	require.Equal(t, "bbb", pinned[0].Hash)
+
+	kept, err := ClearCache("", false)
+	require.NoError(t, err)
+	require.Equal(t, 1, kept)
+	require.FileExists(t, filepath.Join(dir, "bbb.tar.gz"))
+	require.NoFileExists(t, filepath.Join(dir, "aaa.tar.gz"))
+	requireCleared(t, other)
+
+	entries, err := ListCache("")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.True(t, entries[0].Pinned)
+
+	_, err = PinCache("u/r#v1", false)
+	require.NoError(t, err)
+	kept, err = ClearCache("u/r", false)
+	require.NoError(t, err)
+	require.Zero(t, kept)
+	requireCleared(t, dir)
+}
+
+func TestPinnedEntriesSurviveEvictionAndRetention(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	SetCacheRetention(CacheRetention{Hashes: 1})
+	defer SetCacheRetention(DefaultCacheRetention)
+
+	repo, err := ParseRepo("github:u/r#main")
+	require.NoError(t, err)
+	recordHashes(t, repo, "aaa1234")
+	_, err = PinCache("u/r#aaa1234", true)
+	require.NoError(t, err)
+
+	recordHashes(t, repo, "bbb1234")
+	require.Equal(t, []string{"bbb1234", "aaa1234"}, refHistory(t, repo), "a pinned hash outlives the retention")
+
+	freed, err := EvictCache(CacheLimits{MaxEntries: 1}, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), freed)
+	require.FileExists(t, repo.getOutputFile("aaa1234"))
+	require.NoFileExists(t, repo.getOutputFile("bbb1234"))
+}
+
+func TestPinUncachedRef(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	_, err := PinCache("u/r#main", true)
+	require.ErrorContains(t, err, "not cached")
+}
@@ -0,0 +1,88 @@
+package degit
+
+import (
+	"path"
+	"strings"
+	"time"
+)
+
+// PruneOptions selects the cached refs PruneCache removes. Empty fields
+// match everything.
+type PruneOptions struct {
+	OlderThan time.Duration // only refs not used within this window
+	Site      string        // only repositories on this site, e.g. "gitlab"
+	User      string        // only repositories of this user or organization
+	DryRun    bool          // report what would be pruned without deleting anything
+}
+
+// PruneResult reports what PruneCache removed, or would remove on a dry run.
+type PruneResult struct {
+	Refs  []CacheEntry // entries dropped from the cache index
+	Freed int64        // bytes of cached data deleted
+}
+
+// PruneCache drops the unpinned entries matching opts from the cache index.
+// Cached data is deleted only once no remaining entry refers to it anymore,
+// so a tarball shared with an active branch or tag is kept.
+func PruneCache(opts PruneOptions, verbose bool) (*PruneResult, error) {
+	idx, err := loadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	site := strings.TrimSuffix(strings.TrimSuffix(opts.Site, ".com"), ".org")
+	cutoff := time.Now().Add(-opts.OlderThan)
+	stale := func(e *CacheEntry) bool {
+		if e.Pinned {
+			return false
+		}
+		if (site != "" && e.Site != site) || (opts.User != "" && e.User != opts.User) {
+			return false
+		}
+		return opts.OlderThan <= 0 || !e.LastAccess.After(cutoff)
+	}
+
+	result := &PruneResult{}
+	var dirs []string
+	seen := map[string]bool{}
+	for _, e := range idx.Entries {
+		if !stale(e) {
+			continue
+		}
+		result.Refs = append(result.Refs, *e)
+		if dir := path.Join(GetCacheDir(), e.Site, e.User, e.Name); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+
+	if opts.DryRun {
+		kept, dropped := partition(idx.Entries, stale)
+		idx.Entries = kept
+		result.Freed = idx.sizeUnreferenced(dropped)
+		return result, nil
+	}
+
+	for _, dir := range dirs {
+		freed, err := pruneRepo(dir, stale, verbose)
+		result.Freed += freed
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// pruneRepo drops the stale entries of the repository cached in dir.
+func pruneRepo(dir string, stale func(*CacheEntry) bool, verbose bool) (int64, error) {
+	unlock, err := lockDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	log(verbose, "pruning cache", dir)
+	return dropEntries(func(e *CacheEntry) bool {
+		return path.Join(GetCacheDir(), e.Site, e.User, e.Name) == dir && stale(e)
+	})
+}
@@ -0,0 +1,63 @@
+package degit
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPruneCacheKeepsRecentlyUsed(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	now := time.Now()
+
+	dir := seedRepoCache(t, "gitlab/myorg/tpl",
+		map[string]string{"main": "aaa", "v1": "bbb", "HEAD": "bbb"},
+		map[string]time.Time{
+			"main": now.Add(-60 * 24 * time.Hour),
+			"v1":   now.Add(-60 * 24 * time.Hour),
+			"HEAD": now.Add(-time.Hour),
+		}, 100)
+	other := seedRepoCache(t, "github/myorg/tpl",
+		map[string]string{"main": "ccc"},
+		map[string]time.Time{"main": now.Add(-60 * 24 * time.Hour)}, 100)
+
+	opts := PruneOptions{OlderThan: 30 * 24 * time.Hour, Site: "gitlab", DryRun: true}
+	plan, err := PruneCache(opts, false)
+	require.NoError(t, err)
+	require.Len(t, plan.Refs, 2)
+	require.Equal(t, int64(100), plan.Freed)
+	require.FileExists(t, filepath.Join(dir, "aaa.tar.gz"), "a dry run must not delete anything")
+
+	opts.DryRun = false
+	result, err := PruneCache(opts, false)
+	require.NoError(t, err)
+	require.Equal(t, plan.Freed, result.Freed)
+
+	require.NoFileExists(t, filepath.Join(dir, "aaa.tar.gz"))
+	require.FileExists(t, filepath.Join(dir, "bbb.tar.gz"), "HEAD still uses bbb")
+	require.FileExists(t, filepath.Join(other, "ccc.tar.gz"), "other sites are filtered out")
+
+	entries, err := ListCache("gitlab:myorg/tpl")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "HEAD", entries[0].Ref)
+	require.Equal(t, "bbb", entries[0].Hash)
+}
+
+func TestPruneCacheByUserOnly(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	now := time.Now()
+
+	mine := seedRepoCache(t, "github/myorg/a",
+		map[string]string{"main": "aaa"}, map[string]time.Time{"main": now}, 10)
+	theirs := seedRepoCache(t, "github/other/b",
+		map[string]string{"main": "bbb"}, map[string]time.Time{"main": now}, 10)
+
+	result, err := PruneCache(PruneOptions{User: "myorg"}, false)
+	require.NoError(t, err)
+	require.Len(t, result.Refs, 1)
+	require.NoFileExists(t, filepath.Join(mine, "aaa.tar.gz"))
+	require.FileExists(t, filepath.Join(theirs, "bbb.tar.gz"))
+}
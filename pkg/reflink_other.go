@@ -0,0 +1,13 @@
+//go:build !linux
+
+package degit
+
+import (
+	"errors"
+	"os"
+)
+
+// reflink is only implemented on Linux.
+func reflink(src, dst string, mode os.FileMode) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,103 @@
+package degit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshReplacesCachedTarball(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	var body atomic.Value
+	archive := func(content string) []byte {
+		b, err := os.ReadFile(writeTarGz(t, []tarEntry{{name: "r-abc/README.md", content: content}}))
+		require.NoError(t, err)
+		return b
+	}
+	body.Store(archive("old"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(body.Load().([]byte))
+	}))
+	defer server.Close()
+
+	clone := func(refresh bool) string {
+		repo := newTestRepo(server.URL, nil)
+		repo.Ref, repo.Hash, repo.Refresh = "main", "abc", refresh
+		dst := filepath.Join(t.TempDir(), "out")
+		require.NoError(t, repo.Clone(dst, false, false))
+		return readFile(t, filepath.Join(dst, "README.md"))
+	}
+
+	require.Equal(t, "old", clone(false))
+	body.Store(archive("new"))
+	require.Equal(t, "old", clone(false), "without --refresh the cache is used")
+	require.Equal(t, "new", clone(true))
+	require.Equal(t, "new", clone(false), "the refreshed tarball replaced the cached one")
+
+	entries, err := ListCache("")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	sum, err := sha256File(newTestRepo(server.URL, nil).getOutputFile("abc"))
+	require.NoError(t, err)
+	require.Equal(t, sum, entries[0].SHA256)
+
+	objects, err := filepath.Glob(filepath.Join(GetCacheDir(), objectDirName, "abc", "*"))
+	require.NoError(t, err)
+	require.Len(t, objects, 1, "the replaced object is deleted")
+}
+
+func TestRefreshReplacesCachedFile(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	var content atomic.Value
+	content.Store("old")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repository/archive.tar.gz" {
+			_, _ = w.Write([]byte(content.Load().(string)))
+			return
+		}
+		b, err := os.ReadFile(writeTarGz(t, []tarEntry{{name: "r-abc/docs/README.md", content: content.Load().(string)}}))
+		require.NoError(t, err)
+		_, _ = w.Write(b)
+	}))
+	defer server.Close()
+
+	clone := func(refresh bool) string {
+		repo := newFileRepo(server.URL)
+		repo.Refresh = refresh
+		dst := filepath.Join(t.TempDir(), "README.md")
+		require.NoError(t, repo.Clone(dst, false, false))
+		return readFile(t, dst)
+	}
+
+	require.Equal(t, "old", clone(false))
+	require.FileExists(t, newFileRepo(server.URL).getBlobFile("abc"))
+	content.Store("new")
+	require.Equal(t, "old", clone(false), "without --refresh the cached file is used")
+	require.Equal(t, "new", clone(true))
+	require.NoFileExists(t, newFileRepo(server.URL).getBlobFile("abc"))
+	require.Equal(t, "new", clone(false), "the refresh replaced the cached file")
+
+	entries, err := ListCache("")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, KindTarball, entries[0].Kind)
+}
+
+func TestNoCacheLeavesCacheUntouched(t *testing.T) {
+	cache := filepath.Join(t.TempDir(), "cache")
+	t.Setenv("DEGIT_CACHE_DIR", cache)
+	archive := writeTarGz(t, []tarEntry{{name: "r-abc/README.md", content: "hello"}})
+
+	repo := newTestRepo(serveArchive(t, archive).URL, nil)
+	repo.Ref, repo.Hash, repo.NoCache = "main", "abc", true
+	dst := filepath.Join(t.TempDir(), "out")
+	require.NoError(t, repo.Clone(dst, false, false))
+
+	require.Equal(t, "hello", readFile(t, filepath.Join(dst, "README.md")))
+	require.NoDirExists(t, cache)
+}
@@ -3,6 +3,8 @@ package degit
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
@@ -26,7 +28,44 @@ type Repo struct {
 	IsFile   bool
 	Progress Progress // optional; nil = silent (default)
 	Hash     string   // populated by Resolve(); the resolved commit hash
-	Cached   bool     // populated by Resolve(); true if the tarball is already in cache
+	Cached   bool     // populated by Resolve(); true if the tarball (or, in file mode, the file) is already in cache
+	// CacheLayer is populated by Resolve(): the shared read-only cache dir
+	// the cache hit came from, or "" for the user cache. See SharedCacheDirs.
+	CacheLayer string
+
+	// HTTPClient is used for archive downloads; nil = http.DefaultClient.
+	// See NewHTTPClient for timeouts, custom CA bundles and mTLS.
+	HTTPClient *http.Client
+	// Sparse fetches only Subdir through a git partial clone instead of the
+	// whole archive, caching a subdir-only archive keyed by hash and Subdir.
+	// Requires git >= 2.25 on PATH; falls back to the archive on failure.
+	Sparse bool
+	// Rewrites redirect the ls-remote and archive URLs to mirrors. Every
+	// matching rule is tried in order before falling back to the origin.
+	Rewrites []RewriteRule
+	// Trees keeps an extracted tree per hash in the cache, so later clones
+	// of the hash copy files instead of decompressing the tarball again.
+	// Files are cloned with reflinks where the file system supports it.
+	Trees bool
+	// Hardlink lets Trees hardlink files into the destination when reflinks
+	// are unsupported. The destination then shares its files with the cache
+	// and must be treated as read-only.
+	Hardlink bool
+	// Refresh ignores the cache and downloads the tarball again, replacing
+	// the cached one atomically. A single file or subdir archive cached for
+	// the clone is dropped.
+	Refresh bool
+	// NoCache downloads through a temporary file and neither reads nor
+	// writes the cache, for one-off clones on ephemeral machines.
+	NoCache bool
+	// Verify checks cached files against the checksums recorded at download
+	// before extracting them. Corrupt files are quarantined and downloaded
+	// again, see VerifyCache.
+	Verify bool
+	// ArchiveHost is the base URL of a "degit serve" proxy, e.g.
+	// http://mirror:8080. Refs are resolved and archives downloaded through
+	// it first, falling back to the rewrites and the origin. See Server.
+	ArchiveHost string
 }
 
 // Resolve discovers the commit hash that r.Ref points to and checks whether
@@ -38,24 +77,108 @@ type Repo struct {
 // first so it can print the resolved ref (or a cache-hit hint) before any
 // download begins.
 func (r *Repo) Resolve() error {
+	// Subdir names cache paths in file and sparse mode; keep it from
+	// escaping the repository cache dir.
+	if subdir := strings.Trim(r.Subdir, "/"); subdir != "" && !filepath.IsLocal(subdir) {
+		return fmt.Errorf("invalid path %s", r.Subdir)
+	}
 	if r.Hash != "" {
 		return nil
 	}
+	var stale string
+	if r.ArchiveHost != "" {
+		// An unreachable proxy falls back to resolving upstream. So does a
+		// proxy that could not reach upstream either, but its cached hash
+		// still beats none when upstream can't be reached from here.
+		hash, isStale, err := r.resolveViaHost()
+		if err == nil && !isStale {
+			r.Hash = hash
+			return r.checkCache()
+		}
+		if err == nil {
+			stale = hash
+		}
+	}
 	refs, err := r.getRefs()
-	if err != nil {
-		return err
+	if err == nil {
+		var hash string
+		if hash, err = r.getHash(refs); err == nil {
+			r.Hash = hash
+			return r.checkCache()
+		}
+	} else if stale != "" {
+		r.Hash = stale
+		return r.checkCache()
 	}
-	hash, err := r.getHash(refs)
-	if err != nil {
+	// An earlier hash is no longer the tip of any remote ref, and offline
+	// no ref can be listed at all, but a cached one can still be cloned.
+	hash, cacheErr := r.retainedHash()
+	if cacheErr != nil || hash == "" {
 		return err
 	}
 	r.Hash = hash
+	return r.checkCache()
+}
 
-	cached, err := exists(r.getOutputFile(hash))
+// retainedHash returns the hash cached for the repository of r that r.Ref
+// abbreviates, or "" when there is none or r.Ref is no commit hash.
+func (r *Repo) retainedHash() (string, error) {
+	if len(r.Ref) < 7 || strings.Trim(r.Ref, "0123456789abcdef") != "" {
+		return "", nil
+	}
+	idx, err := loadIndex()
+	if err != nil {
+		return "", err
+	}
+	for _, e := range idx.Entries {
+		if e.isRepo(r) && strings.HasPrefix(e.Hash, r.Ref) {
+			return e.Hash, nil
+		}
+	}
+	return "", nil
+}
+
+// checkCache sets r.Cached and r.CacheLayer for r.Hash. The user cache
+// takes precedence over the shared layers.
+func (r *Repo) checkCache() error {
+	if r.Refresh || r.NoCache {
+		r.Cached, r.CacheLayer = false, ""
+		return nil
+	}
+	cached, err := exists(r.getOutputFile(r.Hash))
 	if err != nil {
 		return err
 	}
-	r.Cached = cached
+	if !cached && r.IsFile {
+		if cached, err = exists(r.getBlobFile(r.Hash)); err != nil {
+			return err
+		}
+	}
+	if !cached && r.sparse() {
+		if cached, err = exists(r.getSubdirFile(r.Hash)); err != nil {
+			return err
+		}
+	}
+	if !cached && r.trees() {
+		if cached, err = exists(r.getTreeDir(r.Hash)); err != nil {
+			return err
+		}
+	}
+	if !cached {
+		obj, err := findObject(r.Hash)
+		if err != nil {
+			return err
+		}
+		cached = obj != ""
+	}
+	r.Cached, r.CacheLayer = cached, ""
+	if !cached {
+		layer, _, _, err := r.findShared()
+		if err != nil {
+			return err
+		}
+		r.Cached, r.CacheLayer = layer != "", layer
+	}
 	return nil
 }
 
@@ -79,83 +202,299 @@ func (r *Repo) Clone(dst string, force bool, verbose bool) error {
 		return err
 	}
 
+	if r.IsFile {
+		err = os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+	} else {
+		err = os.MkdirAll(dst, os.ModePerm)
+	}
+	if err != nil {
+		return err
+	}
+
+	if r.NoCache {
+		return r.cloneUncached(dst, verbose)
+	}
+	if err := r.clone(dst, verbose); err != nil {
+		return err
+	}
+
+	// The clone itself succeeded, so failing to trim the cache is not an
+	// error for the caller.
+	if _, err := evictCache(cacheLimits, r.getRepoDir(), r.Hash, verbose); err != nil {
+		log(verbose, "could not enforce cache limits:", err)
+	}
+	return nil
+}
+
+// clone fills dst from the cache, downloading into the cache first when
+// needed, while holding the lock on the repository cache directory.
+func (r *Repo) clone(dst string, verbose bool) error {
+	// Another process may have downloaded or replaced the archive since
+	// Resolve looked, so check again once we own the cache directory.
+	unlock, err := lockDir(r.getRepoDir())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := r.checkCache(); err != nil {
+		return err
+	}
+	if r.Verify && r.Cached && r.CacheLayer == "" {
+		if err := r.verifyCached(verbose); err != nil {
+			return err
+		}
+	}
+
+	if r.CacheLayer != "" {
+		return r.cloneShared(dst, verbose)
+	}
+
 	file := r.getOutputFile(r.Hash)
+	if r.Refresh {
+		if err := r.dropDerived(verbose); err != nil {
+			return err
+		}
+	} else if handled, err := r.cloneCached(dst, verbose); handled {
+		return err
+	}
 
-	if !r.Cached {
+	if r.Cached {
+		log(verbose, "using cache for", r.URL)
+		if err := r.record(file, KindTarball, "", nil, verbose); err != nil {
+			return err
+		}
+		err = untar(file, dst, r.Subdir, "", r.IsFile)
+	} else {
 		if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
 			return err
 		}
-		if err := r.download(file, r.Hash, verbose); err != nil {
+		f, cached, streamErr := r.stream(file, dst, verbose)
+		switch {
+		case cached:
+			if err := r.record(file, KindTarball, "", f, verbose); err != nil {
+				return err
+			}
+		case f != nil && streamErr == nil:
+			// File mode abandoned the download before the archive was
+			// cached, but the clone still missed the cache.
+			r.addUse(f, 0, verbose)
+		}
+		err = streamErr
+	}
+
+	if err == nil && r.trees() {
+		r.buildTree(verbose)
+	}
+	return err
+}
+
+// dropDerived deletes the single file or subdir archive cached for the
+// clone of r.Subdir at r.Hash, so a refresh doesn't leave them to serve
+// later clones. The caller holds the lock on the repository cache dir.
+func (r *Repo) dropDerived(verbose bool) error {
+	var files []string
+	if r.IsFile {
+		files = append(files, r.getBlobFile(r.Hash))
+	}
+	if r.sparse() {
+		files = append(files, r.getSubdirFile(r.Hash))
+	}
+	drop := map[string]bool{}
+	for _, file := range files {
+		ok, err := exists(file)
+		if err != nil {
 			return err
 		}
-	} else {
-		log(verbose, "using cache for", r.URL)
+		if !ok {
+			continue
+		}
+		log(verbose, "removing cached", file)
+		rel := relToCache(GetCacheDir(), file)
+		drop[rel] = true
+		removeCached(rel)
 	}
+	if len(drop) == 0 {
+		return nil
+	}
+	_, err := dropEntries(func(e *CacheEntry) bool { return drop[e.File] })
+	return err
+}
 
-	if err := updateCache(filepath.Dir(file), r.Ref, r.Hash, verbose); err != nil {
-		return err
+// cloneCached serves the clone from a single file, a subdir archive or an
+// extracted tree when one is cached or can be fetched on its own, adopting
+// a stored tarball of the same commit first. It reports whether it handled
+// the clone; when it did not, the caller uses the tarball.
+func (r *Repo) cloneCached(dst string, verbose bool) (bool, error) {
+	if ok, err := exists(r.getOutputFile(r.Hash)); err != nil {
+		return true, err
+	} else if !ok {
+		if _, err := r.adoptObject(verbose); err != nil {
+			return true, err
+		}
 	}
 
 	if r.IsFile {
-		err = os.MkdirAll(filepath.Dir(dst), os.ModePerm)
-	} else {
-		err = os.MkdirAll(dst, os.ModePerm)
+		if handled, err := r.cloneFile(dst, verbose); handled {
+			return true, err
+		}
+	}
+	if r.sparse() {
+		if handled, err := r.cloneSubdir(dst, verbose); handled {
+			return true, err
+		}
+	}
+	if r.trees() {
+		return r.cloneTree(dst, verbose)
 	}
+	return false, nil
+}
+
+// cloneUncached streams the archive into dst through a temporary file,
+// leaving the cache untouched.
+func (r *Repo) cloneUncached(dst string, verbose bool) error {
+	tmp, err := os.MkdirTemp("", "degit-")
 	if err != nil {
 		return err
 	}
+	defer os.RemoveAll(tmp)
 
-	return untar(file, dst, r.Subdir, fmt.Sprintf("%s-%s", r.Name, r.Hash), r.IsFile)
+	_, _, err = r.stream(filepath.Join(tmp, r.Hash+".tar.gz"), dst, verbose)
+	return err
 }
 
+// download fetches the archive for hash into dst.
 func (r *Repo) download(dst string, hash string, verbose bool) error {
-	folder, err := os.Create(dst)
+	_, err := r.downloadTo(dst, r.archiveURL(hash), nil, verbose)
+	return err
+}
+
+// fetched describes a completed download.
+type fetched struct {
+	url    string // the URL that served it, after mirror rewrites
+	final  string // url after the host's redirects
+	size   int64
+	sha256 string
+}
+
+// downloadTo fetches url into dst, copying every byte to tee as well when
+// it is non-nil. The body is written to a ".part" file that is renamed to
+// dst only once the download completed, so dst never holds a truncated file.
+// A failed download still returns what was read from the last URL tried.
+func (r *Repo) downloadTo(dst string, url string, tee io.Writer, verbose bool) (*fetched, error) {
+	part := dst + ".part"
+	folder, err := os.Create(part)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer folder.Close()
 
-	var url string
+	if r.Progress != nil {
+		defer r.Progress.Finish()
+	}
+
+	digest := sha256.New()
+	var sink io.Writer = io.MultiWriter(folder, digest)
+	if tee != nil {
+		sink = io.MultiWriter(folder, digest, tee)
+	}
+
+	// Mirrors are tried in rule order with the origin last. An attempt that
+	// already passed bytes on cannot be rewound, so only attempts that
+	// failed before the body started flowing move on to the next URL.
+	urls := r.candidates(url)
+	if proxied := r.proxied(url); proxied != "" {
+		urls = append([]string{proxied}, urls...)
+	}
+	f := &fetched{}
+	for _, candidate := range urls {
+		cw := &countingWriter{w: sink}
+		f.final, err = r.fetchArchive(cw, candidate, verbose)
+		f.url, f.size = candidate, cw.n
+		if err == nil || cw.n > 0 {
+			break
+		}
+		log(verbose, "download failed:", err)
+	}
+	if err == nil {
+		err = folder.Close()
+	}
+	if err != nil {
+		os.Remove(part)
+		return f, err
+	}
+	f.sha256 = hex.EncodeToString(digest.Sum(nil))
+	return f, os.Rename(part, dst)
+}
+
+func (r *Repo) archiveURL(hash string) string {
 	switch r.Site {
 	case "gitlab":
-		url = fmt.Sprintf("%s/repository/archive.tar.gz?ref=%s", r.URL, hash)
+		return fmt.Sprintf("%s/repository/archive.tar.gz?ref=%s", r.URL, hash)
 	case "bitbucket":
-		url = fmt.Sprintf("%s/get/%s.tar.gz", r.URL, hash)
+		return fmt.Sprintf("%s/get/%s.tar.gz", r.URL, hash)
 	default:
-		url = fmt.Sprintf("%s/archive/%s.tar.gz", r.URL, hash)
+		return fmt.Sprintf("%s/archive/%s.tar.gz", r.URL, hash)
 	}
+}
 
+// fetchArchive copies the body at url to w and returns the URL it was
+// finally served from, after redirects.
+func (r *Repo) fetchArchive(w io.Writer, url string, verbose bool) (string, error) {
 	log(verbose, "downloading from", url)
-	resp, err := http.Get(url)
+	resp, err := r.httpClient().Get(url)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		return fmt.Errorf("could not find repository %s", r.URL)
+		return "", fmt.Errorf("could not find repository %s", r.URL)
 	}
 	if resp.StatusCode != 200 {
 		location := resp.Header.Get("Location")
 		if location == "" {
-			return fmt.Errorf("redirect from %s missing Location header", url)
+			return "", fmt.Errorf("redirect from %s missing Location header", url)
 		}
-		return r.download(dst, location, verbose)
+		return r.fetchArchive(w, location, verbose)
 	}
 
-	var sink io.Writer = folder
+	sink := w
 	if r.Progress != nil {
 		r.Progress.Init(resp.ContentLength)
-		defer r.Progress.Finish()
-		sink = io.MultiWriter(folder, r.Progress)
+		sink = io.MultiWriter(w, r.Progress)
 	}
 
 	_, err = io.Copy(sink, resp.Body)
-	return err
+	return resp.Request.URL.String(), err
+}
+
+// trees reports whether the extracted-tree cache applies.
+func (r *Repo) trees() bool {
+	return r.Trees && !r.IsFile
+}
+
+// sparse reports whether a subdir-only partial clone applies.
+func (r *Repo) sparse() bool {
+	return r.Sparse && !r.IsFile && strings.Trim(r.Subdir, "/") != ""
+}
+
+func (r *Repo) archivePrefix() string {
+	return fmt.Sprintf("%s-%s", r.Name, r.Hash)
+}
+
+func (r *Repo) httpClient() *http.Client {
+	if r.HTTPClient != nil {
+		return r.HTTPClient
+	}
+	return http.DefaultClient
 }
 
 func (r *Repo) getOutputFile(hash string) string {
-	return path.Join(GetCacheDir(), r.Site, r.User, r.Name, fmt.Sprintf("%s.tar.gz", hash))
+	return path.Join(r.getRepoDir(), fmt.Sprintf("%s.tar.gz", hash))
+}
+
+func (r *Repo) getRepoDir() string {
+	return path.Join(GetCacheDir(), r.Site, r.User, r.Name)
 }
 
 func (r *Repo) getHash(refs []*ref) (string, error) {
@@ -202,12 +541,15 @@ type ref struct {
 }
 
 func (r *Repo) getRefs() ([]*ref, error) {
-	cmd := exec.Command("git", "ls-remote", r.URL)
-
 	var output []byte
 	var err error
 
-	if output, err = cmd.Output(); err != nil {
+	for _, url := range r.candidates(r.URL) {
+		if output, err = exec.Command("git", "ls-remote", url).Output(); err == nil {
+			break
+		}
+	}
+	if err != nil {
 		return nil, fmt.Errorf("could not find repository %s", r.URL)
 	}
 
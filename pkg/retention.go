@@ -0,0 +1,69 @@
+package degit
+
+import (
+	"sort"
+	"time"
+)
+
+// CacheRetention decides which earlier hashes of a ref stay cached after
+// the ref moves. A hash is kept while it is among the Hashes most recently
+// used ones of its ref, or while it was used within Within.
+type CacheRetention struct {
+	Hashes int           // hashes kept per ref, counting the current one; < 1 means 1
+	Within time.Duration // also keep hashes used this recently; 0 disables
+}
+
+// DefaultCacheRetention keeps the current hash of a ref and the two before
+// it, so rolling back to a recent snapshot does not need a download.
+var DefaultCacheRetention = CacheRetention{Hashes: 3}
+
+var cacheRetention = DefaultCacheRetention
+
+// SetCacheRetention sets the retention applied whenever a clone records
+// the hash a ref resolved to.
+func SetCacheRetention(retention CacheRetention) {
+	cacheRetention = retention
+}
+
+type refHash struct {
+	hash       string
+	lastAccess time.Time
+}
+
+// history returns the hashes of ref in the index, most recently used first.
+func (idx *Index) history(r *Repo, ref string) []refHash {
+	var hashes []refHash
+	byHash := map[string]int{}
+	for _, e := range idx.Entries {
+		if !e.isRepo(r) || e.Ref != ref {
+			continue
+		}
+		i, ok := byHash[e.Hash]
+		if !ok {
+			i = len(hashes)
+			byHash[e.Hash] = i
+			hashes = append(hashes, refHash{hash: e.Hash})
+		}
+		if e.LastAccess.After(hashes[i].lastAccess) {
+			hashes[i].lastAccess = e.LastAccess
+		}
+	}
+	sort.SliceStable(hashes, func(i, j int) bool {
+		return hashes[i].lastAccess.After(hashes[j].lastAccess)
+	})
+	return hashes
+}
+
+// expired returns the hashes of ref that retention no longer keeps. The
+// most recently used hash is always kept.
+func (idx *Index) expired(r *Repo, ref string, retention CacheRetention, now time.Time) map[string]bool {
+	keep := max(retention.Hashes, 1)
+	expired := map[string]bool{}
+	for i, h := range idx.history(r, ref) {
+		if i < keep || (retention.Within > 0 && now.Sub(h.lastAccess) < retention.Within) {
+			continue
+		}
+		expired[h.hash] = true
+	}
+	return expired
+}
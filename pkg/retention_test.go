@@ -0,0 +1,111 @@
+package degit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordHashes records each hash in turn as what repo.Ref resolved to, as
+// a clone of each would.
+func recordHashes(t *testing.T, repo *Repo, hashes ...string) {
+	t.Helper()
+	for _, hash := range hashes {
+		repo.Hash = hash
+		file := repo.getOutputFile(hash)
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0o755))
+		require.NoError(t, os.WriteFile(file, []byte(hash), 0o644))
+		require.NoError(t, repo.record(file, KindTarball, "", nil, false))
+	}
+}
+
+func refHistory(t *testing.T, repo *Repo) []string {
+	t.Helper()
+	idx, err := loadIndex()
+	require.NoError(t, err)
+	var hashes []string
+	for _, h := range idx.history(repo, repo.Ref) {
+		hashes = append(hashes, h.hash)
+	}
+	return hashes
+}
+
+func TestRetentionKeepsLastHashes(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	SetCacheRetention(CacheRetention{Hashes: 2})
+	defer SetCacheRetention(DefaultCacheRetention)
+
+	repo, err := ParseRepo("github:u/r#main")
+	require.NoError(t, err)
+	recordHashes(t, repo, "aaa", "bbb", "ccc")
+
+	require.Equal(t, []string{"ccc", "bbb"}, refHistory(t, repo))
+	require.NoFileExists(t, repo.getOutputFile("aaa"))
+	require.FileExists(t, repo.getOutputFile("bbb"), "the previous snapshot stays cached for a rollback")
+
+	// Rolling back makes the old hash the most recently used one again.
+	recordHashes(t, repo, "bbb")
+	require.Equal(t, []string{"bbb", "ccc"}, refHistory(t, repo))
+}
+
+func TestRetentionKeepsRecentlyUsedHashes(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	SetCacheRetention(CacheRetention{Hashes: 1, Within: time.Hour})
+	defer SetCacheRetention(DefaultCacheRetention)
+
+	repo, err := ParseRepo("github:u/r#main")
+	require.NoError(t, err)
+	recordHashes(t, repo, "aaa", "bbb")
+	require.Equal(t, []string{"bbb", "aaa"}, refHistory(t, repo), "aaa was used within the window")
+
+	// Once the window has passed, only the current hash remains.
+	require.NoError(t, updateIndex(func(idx *Index) error {
+		for _, e := range idx.Entries {
+			if e.Hash == "aaa" {
+				e.LastAccess = e.LastAccess.Add(-2 * time.Hour)
+			}
+		}
+		return nil
+	}))
+	recordHashes(t, repo, "bbb")
+	require.Equal(t, []string{"bbb"}, refHistory(t, repo))
+	require.NoFileExists(t, repo.getOutputFile("aaa"))
+}
+
+func TestCloneRetainedHashNoLongerAtTip(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	remote, head := newLocalRemote(t, map[string]string{"README.md": "current"})
+	old := "1234567" + strings.Repeat("0", 33)
+	require.NotEqual(t, head, old)
+
+	repo := newTestRepo(remote, nil)
+	repo.Ref, repo.Hash = "main", old
+	archive := writeTarGz(t, []tarEntry{{name: "r-" + old + "/README.md", content: "previous"}})
+	body, err := os.ReadFile(archive)
+	require.NoError(t, err)
+	file := repo.getOutputFile(old)
+	require.NoError(t, os.MkdirAll(filepath.Dir(file), 0o755))
+	require.NoError(t, os.WriteFile(file, body, 0o644))
+	require.NoError(t, repo.record(file, KindTarball, "", nil, false))
+
+	// The remote lists no ref at the old hash, and offline none at all;
+	// either way the retained tarball serves the clone. The remote is a
+	// local path, so any download would fail.
+	for _, url := range []string{remote, filepath.Join(t.TempDir(), "missing")} {
+		repo := newTestRepo(url, nil)
+		repo.Ref = old[:7]
+		dst := filepath.Join(t.TempDir(), "out")
+		require.NoError(t, repo.Clone(dst, false, false))
+		require.Equal(t, old, repo.Hash)
+		require.True(t, repo.Cached)
+		require.Equal(t, "previous", readFile(t, filepath.Join(dst, "README.md")))
+	}
+
+	repo = newTestRepo(remote, nil)
+	repo.Ref = "7654321"
+	require.ErrorContains(t, repo.Resolve(), "could not find ref")
+}
@@ -0,0 +1,68 @@
+package degit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RewriteRule rewrites any URL starting with InsteadOf to start with Base
+// instead, like git's url.<base>.insteadOf setting. It lets degit talk to
+// an Artifactory/Nexus remote that mirrors the public hosts.
+type RewriteRule struct {
+	Base      string
+	InsteadOf string
+}
+
+// ParseRewriteRules reads one rule per line in the form
+//
+//	<base> <insteadOf>
+//
+// e.g. "https://artifactory.example.com/github/ https://github.com/".
+// Blank lines and lines starting with # are ignored.
+func ParseRewriteRules(r io.Reader) ([]RewriteRule, error) {
+	var rules []RewriteRule
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseRewriteRule(line)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+func parseRewriteRule(s string) (RewriteRule, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return RewriteRule{}, fmt.Errorf("invalid rewrite rule %q, expected \"<base> <insteadOf>\"", s)
+	}
+	return RewriteRule{Base: fields[0], InsteadOf: fields[1]}, nil
+}
+
+// candidates returns the URLs to try for u: every matching rewrite in rule
+// order, followed by u itself so the origin is the last resort.
+func (r *Repo) candidates(u string) []string {
+	var urls []string
+	seen := map[string]bool{}
+	for _, rule := range r.Rewrites {
+		if rule.InsteadOf == "" || !strings.HasPrefix(u, rule.InsteadOf) {
+			continue
+		}
+		rewritten := rule.Base + strings.TrimPrefix(u, rule.InsteadOf)
+		if !seen[rewritten] {
+			seen[rewritten] = true
+			urls = append(urls, rewritten)
+		}
+	}
+	if !seen[u] {
+		urls = append(urls, u)
+	}
+	return urls
+}
@@ -0,0 +1,95 @@
+package degit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRewriteRules(t *testing.T) {
+	rules, err := ParseRewriteRules(strings.NewReader(`
+# mirror for github archives
+https://artifactory.example.com/github/ https://github.com/
+
+https://nexus.example.com/gitlab/   https://gitlab.com/
+`))
+	require.NoError(t, err)
+	require.Equal(t, []RewriteRule{
+		{Base: "https://artifactory.example.com/github/", InsteadOf: "https://github.com/"},
+		{Base: "https://nexus.example.com/gitlab/", InsteadOf: "https://gitlab.com/"},
+	}, rules)
+
+	_, err = ParseRewriteRules(strings.NewReader("https://only-one-field/"))
+	require.Error(t, err)
+}
+
+func TestCandidatesTriesMirrorsThenOrigin(t *testing.T) {
+	repo := &Repo{Rewrites: []RewriteRule{
+		{Base: "https://a.example.com/gh/", InsteadOf: "https://github.com/"},
+		{Base: "https://b.example.com/gl/", InsteadOf: "https://gitlab.com/"},
+		{Base: "https://c.example.com/gh/", InsteadOf: "https://github.com/"},
+	}}
+
+	require.Equal(t, []string{
+		"https://a.example.com/gh/u/r",
+		"https://c.example.com/gh/u/r",
+		"https://github.com/u/r",
+	}, repo.candidates("https://github.com/u/r"))
+
+	require.Equal(t, []string{"https://bitbucket.org/u/r"},
+		repo.candidates("https://bitbucket.org/u/r"))
+}
+
+func TestDownloadFallsBackToOrigin(t *testing.T) {
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not mirrored", http.StatusNotFound)
+	}))
+	defer mirror.Close()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("from origin"))
+	}))
+	defer origin.Close()
+
+	repo := newTestRepo(origin.URL, nil)
+	repo.Rewrites = []RewriteRule{{Base: mirror.URL, InsteadOf: origin.URL}}
+	dst := filepath.Join(t.TempDir(), "out.tar.gz")
+
+	require.NoError(t, repo.download(dst, "deadbeef", false))
+
+	got, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	require.Equal(t, "from origin", string(got))
+}
+
+func TestDownloadUsesMirror(t *testing.T) {
+	var originHit bool
+	var mirrorPath string
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrorPath = r.URL.Path
+		_, _ = w.Write([]byte("from mirror"))
+	}))
+	defer mirror.Close()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originHit = true
+	}))
+	defer origin.Close()
+
+	repo := newTestRepo(origin.URL, nil)
+	repo.Rewrites = []RewriteRule{{Base: mirror.URL, InsteadOf: origin.URL}}
+	dst := filepath.Join(t.TempDir(), "out.tar.gz")
+
+	require.NoError(t, repo.download(dst, "deadbeef", false))
+	require.Equal(t, "/archive/deadbeef.tar.gz", mirrorPath)
+	require.False(t, originHit, "origin must not be contacted when the mirror succeeds")
+
+	got, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	require.Equal(t, "from mirror", string(got))
+}
@@ -0,0 +1,217 @@
+package degit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Server is a pull-through caching proxy for archives, run by "degit
+// serve". It answers the archive URLs of the supported hosts under a site
+// prefix, e.g. /github/user/repo/archive/<hash>.tar.gz or
+// /gitlab/user/repo/repository/archive.tar.gz?ref=<hash>, from the local
+// cache, downloading the archive upstream on a miss. GET /resolve?src=<src>
+// resolves a ref to its commit hash upstream, or from the cache when the
+// upstream is unreachable. Clients use it through Repo.ArchiveHost.
+type Server struct {
+	// Configure, if non-nil, sets up every Repo the server resolves or
+	// downloads, e.g. its HTTP client and rewrites.
+	Configure func(*Repo) error
+	Verbose   bool
+}
+
+// ResolveResponse is the body of a successful /resolve request.
+type ResolveResponse struct {
+	Hash   string `json:"hash"`
+	Cached bool   `json:"cached"` // the tarball of Hash is in the server's cache
+	Stale  bool   `json:"stale"`  // the upstream was unreachable and Hash comes from the cache
+}
+
+var fullHash = regexp.MustCompile(`^[0-9a-f]{40}([0-9a-f]{24})?$`)
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	log(s.Verbose, req.Method, req.URL.String())
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if req.URL.Path == "/resolve" {
+		s.serveResolve(w, req)
+		return
+	}
+	s.serveArchive(w, req)
+}
+
+func (s *Server) serveResolve(w http.ResponseWriter, req *http.Request) {
+	src := req.URL.Query().Get("src")
+	if src == "" {
+		http.Error(w, "missing src parameter", http.StatusBadRequest)
+		return
+	}
+	r, err := s.repo(src)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := ResolveResponse{}
+	if err := r.Resolve(); err != nil {
+		hash, cacheErr := cachedHash(r)
+		if cacheErr != nil || hash == "" {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		log(s.Verbose, "could not resolve upstream, using the cache:", err)
+		r.Hash, resp.Stale = hash, true
+		if err := r.checkCache(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	resp.Hash, resp.Cached = r.Hash, r.Cached
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// cachedHash returns the most recently used hash cached for the ref of r,
+// or "" when there is none.
+func cachedHash(r *Repo) (string, error) {
+	idx, err := loadIndex()
+	if err != nil {
+		return "", err
+	}
+	// The index lists the hashes of a ref most recently used first.
+	for _, e := range idx.Entries {
+		if e.isRef(r) {
+			return e.Hash, nil
+		}
+	}
+	return "", nil
+}
+
+func (s *Server) serveArchive(w http.ResponseWriter, req *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "/", 4)
+	if len(parts) != 4 {
+		http.NotFound(w, req)
+		return
+	}
+	r, err := s.repo(fmt.Sprintf("%s:%s/%s", parts[0], parts[1], parts[2]))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hash := archiveHash(parts[3], req.URL.Query())
+	if !fullHash.MatchString(hash) {
+		http.Error(w, "archives are served by full commit hash, see /resolve", http.StatusNotFound)
+		return
+	}
+	// Only the archive URL of the repository's own host is answered.
+	if r.archiveURL(hash) != r.URL+"/"+parts[3]+querySuffix(req.URL) {
+		http.NotFound(w, req)
+		return
+	}
+
+	r.Ref, r.Hash = hash, hash
+	if _, err := r.Fetch(s.Verbose); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	f, err := os.Open(r.getOutputFile(hash))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	http.ServeContent(w, req, path.Base(f.Name()), info.ModTime(), f)
+}
+
+// archiveHash extracts the commit hash from the part of an archive URL
+// after the repository, in any of the hosts' layouts.
+func archiveHash(rest string, query url.Values) string {
+	if rest == "repository/archive.tar.gz" {
+		return query.Get("ref")
+	}
+	for _, prefix := range []string{"archive/", "get/"} {
+		if name, ok := strings.CutPrefix(rest, prefix); ok {
+			return strings.TrimSuffix(name, ".tar.gz")
+		}
+	}
+	return ""
+}
+
+func querySuffix(u *url.URL) string {
+	if u.RawQuery == "" {
+		return ""
+	}
+	return "?" + u.RawQuery
+}
+
+// repo parses src and applies s.Configure. The server always reads and
+// writes its cache and never proxies to another server.
+func (s *Server) repo(src string) (*Repo, error) {
+	r, err := ParseRepo(src)
+	if err != nil {
+		return nil, err
+	}
+	// Request paths are not cleaned; keep them from escaping the cache dir.
+	for _, part := range []string{r.User, r.Name} {
+		if part == "." || part == ".." {
+			return nil, fmt.Errorf("invalid repository %s", src)
+		}
+	}
+	if s.Configure != nil {
+		if err := s.Configure(r); err != nil {
+			return nil, err
+		}
+	}
+	r.ArchiveHost, r.NoCache, r.Refresh = "", false, false
+	r.Subdir, r.IsFile = "", false
+	return r, nil
+}
+
+// proxied returns the URL of url on r.ArchiveHost, or "" when there is no
+// archive host or url is not the archive of r.Hash.
+func (r *Repo) proxied(url string) string {
+	if r.ArchiveHost == "" || r.Hash == "" || url != r.archiveURL(r.Hash) {
+		return ""
+	}
+	return strings.TrimSuffix(r.ArchiveHost, "/") + "/" + path.Join(r.Site, r.User, r.Name) + strings.TrimPrefix(url, r.URL)
+}
+
+// resolveViaHost asks r.ArchiveHost for the hash of r.Ref. It reports
+// whether the hash is stale, resolved from the proxy's cache because the
+// proxy could not reach upstream.
+func (r *Repo) resolveViaHost() (hash string, stale bool, err error) {
+	src := fmt.Sprintf("%s:%s/%s#%s", r.Site, r.User, r.Name, r.Ref)
+	u := strings.TrimSuffix(r.ArchiveHost, "/") + "/resolve?src=" + url.QueryEscape(src)
+	resp, err := r.httpClient().Get(u)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", false, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+
+	var body ResolveResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", false, fmt.Errorf("%s: %w", u, err)
+	}
+	if body.Hash == "" {
+		return "", false, fmt.Errorf("%s: no hash in response", u)
+	}
+	return body.Hash, body.Stale, nil
+}
@@ -0,0 +1,169 @@
+package degit
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testHash = "0123456789abcdef0123456789abcdef01234567"
+
+// newTestServer runs a Server whose repositories download from upstream.
+func newTestServer(t *testing.T, upstream string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(&Server{Configure: func(r *Repo) error {
+		r.URL = upstream + "/" + r.User + "/" + r.Name
+		return nil
+	}})
+	t.Cleanup(server.Close)
+	return server
+}
+
+func get(t *testing.T, u string) (int, []byte) {
+	t.Helper()
+	resp, err := http.Get(u)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, body
+}
+
+func TestServerServesArchivesFromCache(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	archive, err := os.ReadFile(writeTarGz(t, []tarEntry{{name: "r-" + testHash + "/README.md", content: "hello"}}))
+	require.NoError(t, err)
+	var downloads atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads.Add(1)
+		_, _ = w.Write(archive)
+	}))
+	defer upstream.Close()
+	server := newTestServer(t, upstream.URL)
+
+	for range 2 {
+		status, body := get(t, server.URL+"/github/u/r/archive/"+testHash+".tar.gz")
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, archive, body)
+	}
+	require.Equal(t, int32(1), downloads.Load())
+
+	status, body := get(t, server.URL+"/gitlab/u/r/repository/archive.tar.gz?ref="+testHash)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, archive, body)
+
+	status, _ = get(t, server.URL+"/github/u/r/archive/0123456.tar.gz")
+	require.Equal(t, http.StatusNotFound, status, "short hashes are not served")
+	status, _ = get(t, server.URL+"/github/u/r/get/"+testHash+".tar.gz")
+	require.Equal(t, http.StatusNotFound, status, "only the host's own archive layout is served")
+	status, _ = get(t, server.URL+"/github/../../archive/"+testHash+".tar.gz")
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestServerResolvesFromCacheWhenUpstreamIsDown(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	archive := writeTarGz(t, []tarEntry{{name: "r-" + testHash + "/README.md", content: "hello"}})
+	upstream := serveArchive(t, archive)
+	repo := newTestRepo(upstream.URL+"/u/r", nil)
+	repo.Ref, repo.Hash = "main", testHash
+	_, err := repo.Fetch(false)
+	require.NoError(t, err)
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	server := newTestServer(t, down.URL)
+
+	status, body := get(t, server.URL+"/resolve?src="+url.QueryEscape("github:u/r#main"))
+	require.Equal(t, http.StatusOK, status, string(body))
+	var resp ResolveResponse
+	require.NoError(t, json.Unmarshal(body, &resp))
+	require.Equal(t, ResolveResponse{Hash: testHash, Cached: true, Stale: true}, resp)
+
+	status, _ = get(t, server.URL+"/resolve?src="+url.QueryEscape("github:u/r#nope"))
+	require.Equal(t, http.StatusBadGateway, status)
+	status, _ = get(t, server.URL+"/resolve")
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestCloneThroughArchiveHost(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	archive, err := os.ReadFile(writeTarGz(t, []tarEntry{{name: "r-" + testHash + "/README.md", content: "hello"}}))
+	require.NoError(t, err)
+	var mu sync.Mutex
+	var requests []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.RequestURI())
+		mu.Unlock()
+		if r.URL.Path == "/resolve" {
+			_ = json.NewEncoder(w).Encode(ResolveResponse{Hash: testHash})
+			return
+		}
+		_, _ = w.Write(archive)
+	}))
+	defer proxy.Close()
+	origin := httptest.NewServer(http.NotFoundHandler())
+	origin.Close()
+
+	repo := newTestRepo(origin.URL+"/u/r", nil)
+	repo.Ref, repo.ArchiveHost = "main", proxy.URL
+	dst := filepath.Join(t.TempDir(), "out")
+	require.NoError(t, repo.Clone(dst, false, false))
+
+	require.Equal(t, testHash, repo.Hash)
+	require.Equal(t, "hello", readFile(t, filepath.Join(dst, "README.md")))
+	require.Equal(t, []string{
+		"/resolve?src=" + url.QueryEscape("github:u/r#main"),
+		"/github/u/r/archive/" + testHash + ".tar.gz",
+	}, requests)
+}
+
+func TestArchiveHostFallsBackToOrigin(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	archive := writeTarGz(t, []tarEntry{{name: "r-" + testHash + "/README.md", content: "hello"}})
+	origin := serveArchive(t, archive)
+	proxy := httptest.NewServer(http.NotFoundHandler())
+	proxy.Close()
+
+	repo := newTestRepo(origin.URL+"/u/r", nil)
+	repo.Ref, repo.Hash, repo.ArchiveHost = "main", testHash, proxy.URL
+	dst := filepath.Join(t.TempDir(), "out")
+	require.NoError(t, repo.Clone(dst, false, false))
+	require.Equal(t, "hello", readFile(t, filepath.Join(dst, "README.md")))
+
+	entries, err := ListCache("")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.True(t, strings.HasPrefix(entries[0].Source, origin.URL), entries[0].Source)
+}
+
+func TestArchiveHostStaleHashResolvesUpstream(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	remote, head := newLocalRemote(t, map[string]string{"README.md": "current"})
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(ResolveResponse{Hash: testHash, Stale: true})
+	}))
+	defer proxy.Close()
+
+	repo := newTestRepo(remote, nil)
+	repo.Ref, repo.ArchiveHost = "HEAD", proxy.URL
+	require.NoError(t, repo.Resolve())
+	require.Equal(t, head, repo.Hash)
+
+	// With upstream out of reach here too, the stale hash is the best
+	// there is.
+	repo = newTestRepo(filepath.Join(t.TempDir(), "missing"), nil)
+	repo.Ref, repo.ArchiveHost = "HEAD", proxy.URL
+	require.NoError(t, repo.Resolve())
+	require.Equal(t, testHash, repo.Hash)
+}
@@ -0,0 +1,179 @@
+package degit
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// cloneSubdir serves a Sparse clone from a cached subdir-only archive,
+// building one with a git partial clone when it is missing. It reports
+// whether it handled the clone; when it did not (the full tarball is
+// already cached, or the partial clone failed) the caller falls back to
+// the tarball.
+func (r *Repo) cloneSubdir(dst string, verbose bool) (bool, error) {
+	tarball, err := exists(r.getOutputFile(r.Hash))
+	if err != nil {
+		return true, err
+	}
+	if tarball {
+		return false, nil
+	}
+
+	archive := r.getSubdirFile(r.Hash)
+	ok, err := exists(archive)
+	if err != nil {
+		return true, err
+	}
+
+	var f *fetched
+	if ok {
+		log(verbose, "using cached subdirectory for", r.URL)
+	} else {
+		if err := os.MkdirAll(filepath.Dir(archive), os.ModePerm); err != nil {
+			return true, err
+		}
+		if f, err = r.sparseArchive(archive, verbose); err != nil {
+			log(verbose, "partial clone failed, falling back to the archive:", err)
+			return false, nil
+		}
+	}
+
+	if err := r.record(archive, KindSubdir, "/"+strings.Trim(r.Subdir, "/"), f, verbose); err != nil {
+		return true, err
+	}
+	return true, untar(archive, dst, r.Subdir, r.archivePrefix(), false)
+}
+
+// sparseArchive fetches only r.Subdir at r.Hash with a blobless, shallow
+// partial clone and a sparse checkout, then packs it into dst laid out like
+// a host archive ("<name>-<hash>/<subdir>/..."), so untar handles both alike.
+func (r *Repo) sparseArchive(dst string, verbose bool) (*fetched, error) {
+	tmp, err := os.MkdirTemp("", "degit-sparse-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	git := func(args ...string) error {
+		log(verbose, "git", strings.Join(args, " "))
+		out, err := exec.Command("git", append([]string{"-C", tmp}, args...)...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+
+	subdir := strings.Trim(r.Subdir, "/")
+	if err := git("init", "-q"); err != nil {
+		return nil, err
+	}
+	if err := git("sparse-checkout", "set", "--no-cone", "/"+subdir+"/"); err != nil {
+		return nil, err
+	}
+	var source string
+	for _, u := range r.candidates(r.URL) {
+		if err = git("fetch", "-q", "--depth", "1", "--filter=blob:none", u, r.Hash); err == nil {
+			source = u
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := git("checkout", "-q", "FETCH_HEAD"); err != nil {
+		return nil, err
+	}
+
+	part := dst + ".part"
+	if err := writeArchive(part, filepath.Join(tmp, subdir), path.Join(r.archivePrefix(), subdir)); err != nil {
+		os.Remove(part)
+		return nil, err
+	}
+	info, err := os.Stat(part)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := sha256File(part)
+	if err != nil {
+		return nil, err
+	}
+	return &fetched{url: source, size: info.Size(), sha256: sum}, os.Rename(part, dst)
+}
+
+// writeArchive packs the tree at root into a gzipped tarball at file, with
+// every entry name prefixed by prefix.
+func writeArchive(file, root, prefix string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gzw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gzw)
+
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(prefix, filepath.ToSlash(rel))
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		in, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		_, err = io.Copy(tw, in)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gzw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// getSubdirFile is the cache path of the subdir-only archive for r.Subdir
+// at hash, named "<hash>_<escaped subdir>.tar.gz".
+func (r *Repo) getSubdirFile(hash string) string {
+	subdir := url.PathEscape(strings.Trim(r.Subdir, "/"))
+	return path.Join(r.getRepoDir(), fmt.Sprintf("%s_%s.tar.gz", hash, subdir))
+}
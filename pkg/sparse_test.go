@@ -0,0 +1,102 @@
+package degit
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newLocalRemote creates a git repository with the given files committed
+// and returns its path and HEAD commit hash.
+func newLocalRemote(t *testing.T, files map[string]string) (string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+	}
+
+	git := func(args ...string) string {
+		args = append([]string{"-C", dir, "-c", "user.name=degit", "-c", "user.email=degit@example.com"}, args...)
+		out, err := exec.Command("git", args...).CombinedOutput()
+		require.NoErrorf(t, err, "git %v: %s", args, out)
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "init")
+	return dir, git("rev-parse", "HEAD")
+}
+
+func TestSparseArchiveContainsOnlySubdir(t *testing.T) {
+	remote, hash := newLocalRemote(t, map[string]string{
+		"README.md":                      "root",
+		"templates/service/main.go":      "package main",
+		"templates/service/cfg/app.yaml": "name: app",
+		"templates/other/main.go":        "package other",
+	})
+
+	repo := &Repo{
+		Name:   "monorepo",
+		URL:    "file://" + remote,
+		Subdir: "/templates/service",
+		Hash:   hash,
+		Sparse: true,
+	}
+	archive := filepath.Join(t.TempDir(), "sub.tar.gz")
+	_, err := repo.sparseArchive(archive, false)
+	require.NoError(t, err)
+
+	dst := t.TempDir()
+	require.NoError(t, untar(archive, dst, repo.Subdir, repo.archivePrefix(), false))
+
+	require.Equal(t, "package main", readFile(t, filepath.Join(dst, "main.go")))
+	require.Equal(t, "name: app", readFile(t, filepath.Join(dst, "cfg", "app.yaml")))
+	entries, err := os.ReadDir(dst)
+	require.NoError(t, err)
+	require.Len(t, entries, 2, "only the subdirectory should be extracted")
+}
+
+func TestCloneSparseCachesSubdirArchive(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	remote, hash := newLocalRemote(t, map[string]string{
+		"README.md":                 "root",
+		"templates/service/main.go": "package main",
+	})
+
+	newRepo := func() *Repo {
+		return &Repo{
+			Site: "github", User: "org", Name: "monorepo", Ref: "HEAD",
+			URL: "file://" + remote, Subdir: "/templates/service",
+			Hash: hash, Sparse: true,
+		}
+	}
+
+	repo := newRepo()
+	require.NoError(t, repo.Clone(filepath.Join(t.TempDir(), "out"), false, false))
+	require.FileExists(t, repo.getSubdirFile(hash))
+	require.NoFileExists(t, repo.getOutputFile(hash))
+
+	// A later clone of the same subdir is a cache hit, even offline.
+	require.NoError(t, os.RemoveAll(remote))
+	repo = newRepo()
+	dst := filepath.Join(t.TempDir(), "out")
+	require.NoError(t, repo.Clone(dst, false, false))
+	require.Equal(t, "package main", readFile(t, filepath.Join(dst, "main.go")))
+}
+
+func TestGetSubdirFileEscapesSlashes(t *testing.T) {
+	repo := &Repo{Site: "github", User: "org", Name: "monorepo", Subdir: "/templates/service"}
+	require.Equal(t,
+		filepath.Join(repo.getRepoDir(), "abc_templates%2Fservice.tar.gz"),
+		repo.getSubdirFile("abc"))
+}
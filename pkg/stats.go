@@ -0,0 +1,125 @@
+package degit
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+)
+
+var statsName = "stats.json"
+
+// RepoStats counts how a repository's clones used the cache.
+type RepoStats struct {
+	Hits            int64 `json:"hits"`             // clones served from the cache
+	Misses          int64 `json:"misses"`           // clones that had to download
+	BytesDownloaded int64 `json:"bytes_downloaded"` // bytes fetched over the network
+	BytesServed     int64 `json:"bytes_served"`     // bytes read from the cache instead
+}
+
+// Stats holds the cache counters of every repository, keyed by
+// "site/user/name". They are stored in stats.json next to the index and
+// survive eviction and pruning, but not clearing the whole cache.
+type Stats struct {
+	Repos map[string]*RepoStats `json:"repos"`
+}
+
+// Total sums the counters of all repositories.
+func (s *Stats) Total() RepoStats {
+	var total RepoStats
+	for _, r := range s.Repos {
+		total.Hits += r.Hits
+		total.Misses += r.Misses
+		total.BytesDownloaded += r.BytesDownloaded
+		total.BytesServed += r.BytesServed
+	}
+	return total
+}
+
+// HitRate is the share of clones served from the cache, between 0 and 1.
+func (r RepoStats) HitRate() float64 {
+	if r.Hits+r.Misses == 0 {
+		return 0
+	}
+	return float64(r.Hits) / float64(r.Hits+r.Misses)
+}
+
+// ReadStats returns the cache counters. stats.json is only ever replaced by
+// a rename, so it is read without the lock.
+func ReadStats() (*Stats, error) {
+	return readStats(GetCacheDir())
+}
+
+func readStats(base string) (*Stats, error) {
+	stats := &Stats{Repos: map[string]*RepoStats{}}
+	b, err := os.ReadFile(path.Join(base, statsName))
+	if os.IsNotExist(err) {
+		return stats, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Counters are informational: start over rather than fail on damage.
+	if err := json.Unmarshal(b, stats); err != nil || stats.Repos == nil {
+		stats.Repos = map[string]*RepoStats{}
+	}
+	return stats, nil
+}
+
+// countUse adds a clone of r to the counters: a download of f, or a cache
+// hit serving size bytes when f is nil. Data derived from files already in
+// the cache (file:// sources) is not a clone and isn't counted. The caller
+// holds the index lock. The counters are informational, so failing to
+// write them is only logged.
+func (r *Repo) countUse(f *fetched, size int64, verbose bool) {
+	if f != nil && strings.HasPrefix(f.url, "file://") {
+		return
+	}
+	if err := r.writeUse(f, size); err != nil {
+		log(verbose, "could not update cache stats:", err)
+	}
+}
+
+// addUse is countUse for callers that don't update the index; it takes the
+// index lock itself without rewriting the index.
+func (r *Repo) addUse(f *fetched, size int64, verbose bool) {
+	unlock, err := lockDir(GetCacheDir())
+	if err != nil {
+		log(verbose, "could not update cache stats:", err)
+		return
+	}
+	defer unlock()
+	r.countUse(f, size, verbose)
+}
+
+func (r *Repo) writeUse(f *fetched, size int64) error {
+
+	base := GetCacheDir()
+	stats, err := readStats(base)
+	if err != nil {
+		return err
+	}
+	key := path.Join(r.Site, r.User, r.Name)
+	s, ok := stats.Repos[key]
+	if !ok {
+		s = &RepoStats{}
+		stats.Repos[key] = s
+	}
+	if f == nil {
+		s.Hits++
+		s.BytesServed += size
+	} else {
+		s.Misses++
+		s.BytesDownloaded += f.size
+	}
+
+	b, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path.Join(base, statsName+".tmp")
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path.Join(base, statsName))
+}
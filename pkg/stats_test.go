@@ -0,0 +1,97 @@
+package degit
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsCountHitsAndMisses(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	archive := writeTarGz(t, []tarEntry{{name: "r-abc/README.md", content: "hello"}})
+	info, err := os.Stat(archive)
+	require.NoError(t, err)
+	url := serveArchive(t, archive).URL
+
+	for range 3 {
+		repo := newTestRepo(url, nil)
+		repo.Ref, repo.Hash = "main", "abc"
+		require.NoError(t, repo.Clone(filepath.Join(t.TempDir(), "out"), false, false))
+	}
+
+	stats, err := ReadStats()
+	require.NoError(t, err)
+	require.Equal(t, map[string]*RepoStats{
+		"github/u/r": {Hits: 2, Misses: 1, BytesDownloaded: info.Size(), BytesServed: 2 * info.Size()},
+	}, stats.Repos)
+	require.InDelta(t, 2.0/3, stats.Total().HitRate(), 0.001)
+}
+
+func TestStatsCountAbandonedStreamsAndSkipPrefetches(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	filler := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(filler)
+	archive := writeTarGz(t, []tarEntry{
+		{name: "r-abc/README.md", content: "hello"},
+		{name: "r-abc/big.bin", content: string(filler)},
+	})
+	url := serveArchive(t, archive).URL
+
+	// The stream stops after the target, so nothing is cached, but the
+	// clone is a miss all the same.
+	repo := newTestRepo(url, nil)
+	repo.Ref, repo.Hash, repo.Subdir, repo.IsFile = "main", "abc", "/README.md", true
+	require.NoError(t, repo.Clone(filepath.Join(t.TempDir(), "README.md"), false, false))
+	stats, err := ReadStats()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), stats.Repos["github/u/r"].Misses)
+	require.Positive(t, stats.Repos["github/u/r"].BytesDownloaded)
+	require.NoFileExists(t, filepath.Join(GetCacheDir(), indexName), "counting the miss must not write the index")
+
+	// Prefetching, whether it downloads or not, is not a clone.
+	for range 2 {
+		repo = newTestRepo(url, nil)
+		repo.Ref, repo.Hash = "main", "abc"
+		_, err = repo.fetch(false)
+		require.NoError(t, err)
+	}
+	require.FileExists(t, repo.getOutputFile("abc"))
+	again, err := ReadStats()
+	require.NoError(t, err)
+	require.Equal(t, stats.Repos, again.Repos)
+}
+
+func TestReadStatsToleratesDamage(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	require.NoError(t, os.WriteFile(filepath.Join(GetCacheDir(), statsName), []byte("{"), 0o644))
+
+	stats, err := ReadStats()
+	require.NoError(t, err)
+	require.Empty(t, stats.Repos)
+}
+
+func TestReadStatsDoesNotCreateCache(t *testing.T) {
+	cache := filepath.Join(t.TempDir(), "cache")
+	t.Setenv("DEGIT_CACHE_DIR", cache)
+
+	stats, err := ReadStats()
+	require.NoError(t, err)
+	require.Empty(t, stats.Repos)
+	require.NoDirExists(t, cache)
+}
+
+func TestCloneSucceedsWhenStatsCannotBeWritten(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	// A non-empty directory in place of stats.json can't be replaced.
+	require.NoError(t, os.MkdirAll(filepath.Join(GetCacheDir(), statsName, "x"), 0o755))
+	archive := writeTarGz(t, []tarEntry{{name: "r-abc/README.md", content: "hello"}})
+
+	repo := newTestRepo(serveArchive(t, archive).URL, nil)
+	repo.Ref, repo.Hash = "main", "abc"
+	dst := filepath.Join(t.TempDir(), "out")
+	require.NoError(t, repo.Clone(dst, false, false))
+	require.Equal(t, "hello", readFile(t, filepath.Join(dst, "README.md")))
+}
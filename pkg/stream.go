@@ -0,0 +1,58 @@
+package degit
+
+import (
+	"errors"
+	"io"
+)
+
+// errExtracted aborts a streaming download once file mode has written its
+// target; the rest of the archive is not needed.
+var errExtracted = errors.New("target extracted")
+
+// stream downloads the archive into the cache file while extracting it into
+// dst at the same time, so the tarball is only read once. It returns the
+// download and whether the archive was fully committed to file. In file
+// mode the download is abandoned as soon as the target entry has been
+// written, unless r.Refresh; f then only describes what was read.
+func (r *Repo) stream(file, dst string, verbose bool) (f *fetched, cached bool, err error) {
+	pr, pw := io.Pipe()
+	extracted := make(chan error, 1)
+
+	go func() {
+		err := extract(pr, dst, r.Subdir, "", r.IsFile)
+		// Refresh replaces the cached tarball, so it reads to the end.
+		if err == nil && r.IsFile && !r.Refresh {
+			pr.CloseWithError(errExtracted)
+		} else {
+			// Keep reading so the download can still complete and be
+			// cached: the tar end marker may be followed by padding, and
+			// a failed extraction shouldn't throw the archive away.
+			_, _ = io.Copy(io.Discard, pr)
+		}
+		extracted <- err
+	}()
+
+	f, err = r.downloadTo(file, r.archiveURL(r.Hash), pw, verbose)
+	pw.CloseWithError(err)
+	extractErr := <-extracted
+
+	if errors.Is(err, errExtracted) {
+		return f, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return f, true, extractErr
+}
+
+// countingWriter counts the bytes passed through to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
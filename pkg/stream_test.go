@@ -0,0 +1,133 @@
+package degit
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func serveArchive(t *testing.T, archive string) *httptest.Server {
+	t.Helper()
+	body, err := os.ReadFile(archive)
+	require.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestStreamExtractsAndCommitsArchive(t *testing.T) {
+	archive := writeTarGz(t, []tarEntry{
+		{name: "r-abc/", isDir: true},
+		{name: "r-abc/README.md", content: "hello"},
+		{name: "r-abc/lib/foo.go", content: "package foo"},
+	})
+	repo := newTestRepo(serveArchive(t, archive).URL, nil)
+	repo.Hash = "abc"
+
+	file := filepath.Join(t.TempDir(), "abc.tar.gz")
+	dst := t.TempDir()
+
+	f, cached, err := repo.stream(file, dst, false)
+	require.NoError(t, err)
+	require.True(t, cached)
+	require.NotNil(t, f)
+
+	require.Equal(t, "hello", readFile(t, filepath.Join(dst, "README.md")))
+	require.Equal(t, "package foo", readFile(t, filepath.Join(dst, "lib", "foo.go")))
+
+	want, err := os.ReadFile(archive)
+	require.NoError(t, err)
+	got, err := os.ReadFile(file)
+	require.NoError(t, err)
+	require.Equal(t, want, got, "the cache file must hold the complete archive")
+	require.NoFileExists(t, file+".part")
+
+	sum, err := sha256File(file)
+	require.NoError(t, err)
+	require.Equal(t, sum, f.sha256)
+	require.Equal(t, int64(len(want)), f.size)
+}
+
+func TestStreamFileModeStopsAfterTarget(t *testing.T) {
+	// Incompressible filler, so the archive spans many reads and the
+	// download is still in flight when the target has been written.
+	filler := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(filler)
+
+	archive := writeTarGz(t, []tarEntry{
+		{name: "r-abc/", isDir: true},
+		{name: "r-abc/README.md", content: "hello"},
+		{name: "r-abc/big.bin", content: string(filler)},
+	})
+	repo := newTestRepo(serveArchive(t, archive).URL, nil)
+	repo.Hash = "abc"
+	repo.Subdir = "/README.md"
+	repo.IsFile = true
+
+	file := filepath.Join(t.TempDir(), "abc.tar.gz")
+	dst := filepath.Join(t.TempDir(), "README.md")
+
+	f, cached, err := repo.stream(file, dst, false)
+	require.NoError(t, err)
+	require.False(t, cached, "an abandoned download must not be committed to the cache")
+	require.Positive(t, f.size)
+
+	require.Equal(t, "hello", readFile(t, dst))
+	require.NoFileExists(t, file)
+	require.NoFileExists(t, file+".part")
+}
+
+func TestStreamCommitsArchiveWhenExtractionFails(t *testing.T) {
+	archive := writeTarGz(t, []tarEntry{
+		{name: "r-abc/README.md", content: "hello"},
+	})
+	repo := newTestRepo(serveArchive(t, archive).URL, nil)
+	repo.Hash = "abc"
+	repo.Subdir = "/missing.md"
+	repo.IsFile = true
+
+	file := filepath.Join(t.TempDir(), "abc.tar.gz")
+	dst := filepath.Join(t.TempDir(), "missing.md")
+
+	f, cached, err := repo.stream(file, dst, false)
+	require.Error(t, err)
+	require.True(t, cached)
+	require.NotNil(t, f)
+	require.FileExists(t, file)
+}
+
+func TestStreamHostArchiveLayouts(t *testing.T) {
+	for site, root := range map[string]string{
+		"gitlab":    "r-abc-abc",
+		"bitbucket": "u-r-abc",
+	} {
+		t.Run(site, func(t *testing.T) {
+			archive := writeTarGz(t, []tarEntry{
+				{name: root + "/", isDir: true},
+				{name: root + "/README.md", content: "hello"},
+			})
+			url := serveArchive(t, archive).URL
+
+			repo := newTestRepo(url, nil)
+			repo.Site, repo.Hash = site, "abc"
+			dst := t.TempDir()
+			_, _, err := repo.stream(filepath.Join(t.TempDir(), "abc.tar.gz"), dst, false)
+			require.NoError(t, err)
+			require.Equal(t, "hello", readFile(t, filepath.Join(dst, "README.md")))
+
+			repo = newTestRepo(url, nil)
+			repo.Site, repo.Hash, repo.Subdir, repo.IsFile = site, "abc", "/README.md", true
+			out := filepath.Join(t.TempDir(), "README.md")
+			_, _, err = repo.stream(filepath.Join(t.TempDir(), "abc.tar.gz"), out, false)
+			require.NoError(t, err)
+			require.Equal(t, "hello", readFile(t, out))
+		})
+	}
+}
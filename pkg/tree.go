@@ -0,0 +1,154 @@
+package degit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// treeDirName holds extracted trees, laid out as trees/<hash>/.
+var treeDirName = "trees"
+
+// getTreeDir is the cache path of the extracted tree at hash.
+func (r *Repo) getTreeDir(hash string) string {
+	return path.Join(r.getRepoDir(), treeDirName, hash)
+}
+
+// cloneTree serves a clone from the extracted tree of r.Hash. It reports
+// whether it handled the clone; when the tree is not cached yet the caller
+// falls back to the tarball and then calls buildTree.
+func (r *Repo) cloneTree(dst string, verbose bool) (bool, error) {
+	tree := r.getTreeDir(r.Hash)
+	ok, err := exists(tree)
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	log(verbose, "using extracted tree for", r.URL)
+	if err := r.record(tree, KindTree, "", nil, verbose); err != nil {
+		return true, err
+	}
+	src := filepath.Join(tree, filepath.FromSlash(strings.Trim(r.Subdir, "/")))
+	if ok, err := exists(src); err != nil || !ok {
+		// Like untar, a missing subdirectory yields an empty destination.
+		return true, err
+	}
+	return true, materialize(src, dst, r.Hardlink)
+}
+
+// buildTree extracts the cached tarball of r.Hash into the tree cache. A
+// failure only costs the next clone its shortcut, so it is logged rather
+// than returned.
+func (r *Repo) buildTree(verbose bool) {
+	tree := r.getTreeDir(r.Hash)
+	tmp := tree + ".part"
+	os.RemoveAll(tmp)
+
+	err := untar(r.getOutputFile(r.Hash), tmp, "", "", false)
+	if err == nil {
+		// After a refresh the previous tree is replaced.
+		if err = os.RemoveAll(tree); err == nil {
+			err = os.Rename(tmp, tree)
+		}
+	}
+	if err == nil {
+		var size int64
+		if size, err = dirSize(tree); err == nil {
+			err = r.record(tree, KindTree, "", &fetched{url: "file://" + r.getOutputFile(r.Hash), size: size}, verbose)
+		}
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		log(verbose, "could not cache the extracted tree:", err)
+	}
+}
+
+// linkFile hardlinks files for materialize; tests replace it to simulate
+// destinations on another file system.
+var linkFile = os.Link
+
+// materialize recreates the tree at src in dst, cloning every file with a
+// reflink where the file system supports it. Otherwise files are hardlinked
+// when hardlink is set, in which case dst shares its files with the cache
+// and must be treated as read-only, or else copied, e.g. when dst is on
+// another file system than the cache.
+func materialize(src, dst string, hardlink bool) error {
+	useReflink, useHardlink := true, hardlink
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, os.ModePerm)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case !info.Mode().IsRegular():
+			return nil
+		}
+
+		if useReflink {
+			if reflink(p, target, info.Mode().Perm()) == nil {
+				return nil
+			}
+			// The file system has no reflinks; don't retry for every file.
+			useReflink = false
+		}
+		if useHardlink {
+			if linkFile(p, target) == nil {
+				return nil
+			}
+			useHardlink = false
+		}
+		return copyFileMode(p, target, info.Mode().Perm())
+	})
+}
+
+func copyFileMode(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// dirSize returns the total size of the regular files under dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not size %s: %w", dir, err)
+	}
+	return size, nil
+}
@@ -0,0 +1,103 @@
+package degit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloneFromExtractedTree(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	archive := writeTarGz(t, []tarEntry{
+		{name: "r-abc/", isDir: true},
+		{name: "r-abc/README.md", content: "hello"},
+		{name: "r-abc/lib/foo.go", content: "package foo", mode: 0o600},
+	})
+	body, err := os.ReadFile(archive)
+	require.NoError(t, err)
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	clone := func(subdir string) string {
+		repo := newTestRepo(server.URL, nil)
+		repo.Ref, repo.Hash, repo.Subdir, repo.Trees = "main", "abc", subdir, true
+		dst := filepath.Join(t.TempDir(), "out")
+		require.NoError(t, repo.Clone(dst, false, false))
+		return dst
+	}
+
+	dst := clone("")
+	require.Equal(t, "hello", readFile(t, filepath.Join(dst, "README.md")))
+	repo := newTestRepo(server.URL, nil)
+	require.DirExists(t, repo.getTreeDir("abc"))
+
+	// Without the tarball, the tree alone serves the clone.
+	require.NoError(t, os.Remove(repo.getOutputFile("abc")))
+	dst = clone("/lib")
+	require.Equal(t, "package foo", readFile(t, filepath.Join(dst, "foo.go")))
+	info, err := os.Stat(filepath.Join(dst, "foo.go"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	require.Equal(t, int32(1), hits.Load())
+
+	entries, err := ListCache("")
+	require.NoError(t, err)
+	var kinds []string
+	for _, e := range entries {
+		kinds = append(kinds, e.Kind)
+	}
+	require.Contains(t, kinds, KindTree)
+}
+
+func TestMaterializeHardlinkFallback(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(src, "a.txt"), []byte("a"), 0o644))
+
+	if reflink(filepath.Join(src, "a.txt"), filepath.Join(t.TempDir(), "probe"), 0o644) == nil {
+		t.Skip("the file system supports reflinks, which take precedence")
+	}
+
+	require.NoError(t, materialize(src, dst, true))
+	a, err := os.Stat(filepath.Join(src, "a.txt"))
+	require.NoError(t, err)
+	b, err := os.Stat(filepath.Join(dst, "a.txt"))
+	require.NoError(t, err)
+	require.True(t, os.SameFile(a, b))
+
+	copied := t.TempDir()
+	require.NoError(t, materialize(src, copied, false))
+	c, err := os.Stat(filepath.Join(copied, "a.txt"))
+	require.NoError(t, err)
+	require.False(t, os.SameFile(a, c))
+	require.Equal(t, "a", readFile(t, filepath.Join(copied, "a.txt")))
+}
+
+func TestMaterializeCopiesAcrossFileSystems(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "bin"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "bin", "run.sh"), []byte("#!/bin/sh"), 0o755))
+
+	if reflink(filepath.Join(src, "bin", "run.sh"), filepath.Join(t.TempDir(), "probe"), 0o644) == nil {
+		t.Skip("the file system supports reflinks, which take precedence")
+	}
+	linkFile = func(string, string) error {
+		return &os.LinkError{Op: "link", Err: syscall.EXDEV}
+	}
+	t.Cleanup(func() { linkFile = os.Link })
+
+	require.NoError(t, materialize(src, dst, true))
+	require.Equal(t, "#!/bin/sh", readFile(t, filepath.Join(dst, "bin", "run.sh")))
+	info, err := os.Stat(filepath.Join(dst, "bin", "run.sh"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o755), info.Mode().Perm())
+}
@@ -17,7 +17,14 @@ func untar(file, dst, subdir, prefix string, isFile bool) error {
 	}
 	defer f.Close()
 
-	gzr, err := gzip.NewReader(f)
+	return extract(f, dst, subdir, prefix, isFile)
+}
+
+// extract reads a gzipped tarball from r into dst. In file mode it returns
+// as soon as the target entry has been written, without consuming the rest
+// of the stream. An empty prefix is taken from the first entry.
+func extract(r io.Reader, dst, subdir, prefix string, isFile bool) error {
+	gzr, err := gzip.NewReader(r)
 	if err != nil {
 		return err
 	}
@@ -45,6 +52,14 @@ func untar(file, dst, subdir, prefix string, isFile bool) error {
 			continue
 		}
 
+		// Host archives hold a single top-level directory, named after
+		// the host's own scheme (GitLab repeats the hash, Bitbucket adds
+		// the user) and, for a tarball cached for a fork, after that
+		// fork. Archives are read with prefix "" so the directory is
+		// taken from the first entry.
+		if prefix == "" {
+			prefix, _, _ = strings.Cut(header.Name, "/")
+		}
 		header.Name = strings.TrimPrefix(header.Name, prefix)
 
 		if isFile {
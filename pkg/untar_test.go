@@ -181,3 +181,19 @@ func TestUntarSingleFileNotFound(t *testing.T) {
 		t.Errorf("dst should not exist when file is not found")
 	}
 }
+
+func TestUntarDetectsPrefix(t *testing.T) {
+	src := writeTarGz(t, []tarEntry{
+		{name: "fork-abc/", isDir: true},
+		{name: "fork-abc/lib/", isDir: true},
+		{name: "fork-abc/lib/foo.go", content: "package foo"},
+	})
+	dst := t.TempDir()
+
+	if err := untar(src, dst, "/lib", "", false); err != nil {
+		t.Fatalf("untar: %v", err)
+	}
+	if got := readFile(t, filepath.Join(dst, "foo.go")); got != "package foo" {
+		t.Errorf("foo.go content: got %q, want %q", got, "package foo")
+	}
+}
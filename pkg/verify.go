@@ -0,0 +1,201 @@
+package degit
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// quarantineDirName holds the corrupt files moved aside by VerifyCache,
+// laid out like the cache dir.
+var quarantineDirName = ".quarantine"
+
+// VerifyResult reports what VerifyCache checked and found corrupt.
+type VerifyResult struct {
+	Checked int           // cached files checked
+	Corrupt []CorruptFile // files that failed the check
+}
+
+// CorruptFile is a cached file that failed verification.
+type CorruptFile struct {
+	File    string       // relative to the cache dir
+	Entries []CacheEntry // the index entries referring to it
+	Err     error        // what is wrong with it
+}
+
+// VerifyCache re-hashes the cached tarballs, subdir archives and single
+// files of the repositories matching filter (see ClearCache) and compares
+// them with the checksums recorded when they were downloaded. Archives are
+// also decoded to the end. With quarantine, corrupt files are moved to the
+// .quarantine directory of the cache and dropped from the index, so the
+// next clone downloads them again. Extracted trees carry no checksum and
+// are skipped.
+func VerifyCache(filter string, quarantine bool, verbose bool) (*VerifyResult, error) {
+	match, err := parseRepoFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	result := &VerifyResult{}
+	if ok, err := exists(GetCacheDir()); err != nil || !ok {
+		return result, err
+	}
+	idx, err := loadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	entries := map[string][]CacheEntry{}
+	for _, e := range idx.Entries {
+		if e.Kind == KindTree || !match(e.Site, e.User, e.Name) {
+			continue
+		}
+		if _, ok := entries[e.File]; !ok {
+			files = append(files, e.File)
+		}
+		entries[e.File] = append(entries[e.File], *e)
+	}
+
+	quarantined := map[string]bool{}
+	for _, file := range files {
+		if quarantined[file] {
+			continue
+		}
+		e := entries[file][0]
+		bad, dropped, err := verifyFile(path.Join(GetCacheDir(), e.Site, e.User, e.Name), &e, quarantine, verbose)
+		if err != nil {
+			return result, err
+		}
+		result.Checked++
+		if bad == nil {
+			continue
+		}
+		result.Corrupt = append(result.Corrupt, CorruptFile{File: file, Entries: entries[file], Err: bad})
+
+		// Files linked to the same object are the same corrupt data and
+		// were quarantined along with it.
+		var shared []string
+		sharedEntries := map[string][]CacheEntry{}
+		for _, d := range dropped {
+			if d.File == file {
+				continue
+			}
+			if _, ok := sharedEntries[d.File]; !ok {
+				shared = append(shared, d.File)
+			}
+			sharedEntries[d.File] = append(sharedEntries[d.File], d)
+		}
+		for _, f := range shared {
+			quarantined[f] = true
+			result.Corrupt = append(result.Corrupt, CorruptFile{File: f, Entries: sharedEntries[f], Err: bad})
+		}
+	}
+	return result, nil
+}
+
+// verifyFile checks the file of e under the lock of its repository cache
+// dir, quarantining it when asked to. It returns what is wrong with the
+// file and the index entries quarantining dropped, or an error when it
+// could not be checked or moved.
+func verifyFile(dir string, e *CacheEntry, quarantine bool, verbose bool) (bad error, dropped []CacheEntry, err error) {
+	unlock, err := lockDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	p := path.Join(GetCacheDir(), e.File)
+	log(verbose, "verifying", p)
+	if bad = checkFile(p, e); bad != nil && quarantine {
+		dropped, err = quarantineFile(e.File, verbose)
+	}
+	return bad, dropped, err
+}
+
+// checkFile compares the file at p with the checksum recorded in e and,
+// for archives, reads it to the end.
+func checkFile(p string, e *CacheEntry) error {
+	if e.SHA256 != "" {
+		sum, err := sha256File(p)
+		if err != nil {
+			return err
+		}
+		if sum != e.SHA256 {
+			return fmt.Errorf("checksum mismatch: recorded %.12s, found %.12s", e.SHA256, sum)
+		}
+	}
+	if e.Kind == KindFile {
+		return nil
+	}
+	return checkArchive(p)
+}
+
+// quarantineFile moves the cached file rel, relative to the cache dir, into
+// the quarantine directory and drops the index entries referring to it,
+// returning them. Its stored object is the same file, and so are the files
+// of other forks linked to it: they are quarantined and dropped as well,
+// and the object removed. The caller holds the lock on the repository
+// cache dir.
+func quarantineFile(rel string, verbose bool) ([]CacheEntry, error) {
+	base := GetCacheDir()
+	var result []CacheEntry
+	err := updateIndex(func(idx *Index) error {
+		files := map[string]bool{rel: true}
+		for _, e := range idx.Entries {
+			if e.File != rel || e.Object == "" {
+				continue
+			}
+			for _, o := range idx.Entries {
+				if o.Object == e.Object {
+					files[o.File] = true
+				}
+			}
+		}
+
+		var dropped []*CacheEntry
+		idx.Entries, dropped = partition(idx.Entries, func(e *CacheEntry) bool { return files[e.File] })
+		for file := range files {
+			dst := path.Join(base, quarantineDirName, file)
+			if err := os.MkdirAll(path.Dir(dst), os.ModePerm); err != nil {
+				return err
+			}
+			if err := os.Rename(path.Join(base, file), dst); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			log(verbose, "quarantined corrupt cache file to", dst)
+		}
+		idx.removeUnreferenced(dropped)
+		for _, e := range dropped {
+			result = append(result, *e)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// verifyCached checks the cached files of r.Hash against their recorded
+// checksums before a clone uses them. Corrupt ones are quarantined and the
+// cache is checked again, so the clone downloads them anew. The caller
+// holds the lock on the repository cache dir.
+func (r *Repo) verifyCached(verbose bool) error {
+	idx, err := loadIndex()
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, e := range idx.Entries {
+		if !e.isRepo(r) || e.Hash != r.Hash || e.Kind == KindTree || e.SHA256 == "" || seen[e.File] {
+			continue
+		}
+		seen[e.File] = true
+		sum, err := sha256File(path.Join(GetCacheDir(), e.File))
+		if os.IsNotExist(err) || (err == nil && sum == e.SHA256) {
+			continue
+		}
+		log(verbose, "cached file does not match its checksum:", e.File)
+		if _, err := quarantineFile(e.File, verbose); err != nil {
+			return err
+		}
+	}
+	return r.checkCache()
+}
@@ -0,0 +1,120 @@
+package degit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyCache(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	archive := writeTarGz(t, []tarEntry{{name: "r-abc/README.md", content: "hello"}})
+	repo := newTestRepo(serveArchive(t, archive).URL, nil)
+	repo.Ref, repo.Hash = "main", "abc"
+	require.NoError(t, repo.Clone(filepath.Join(t.TempDir(), "out"), false, false))
+
+	result, err := VerifyCache("", false, false)
+	require.NoError(t, err)
+	require.Equal(t, 1, result.Checked)
+	require.Empty(t, result.Corrupt)
+
+	file := repo.getOutputFile("abc")
+	require.NoError(t, os.WriteFile(file, []byte("garbage"), 0o644))
+
+	result, err = VerifyCache("github:u/*", false, false)
+	require.NoError(t, err)
+	require.Len(t, result.Corrupt, 1)
+	require.Equal(t, "github/u/r/abc.tar.gz", result.Corrupt[0].File)
+	require.ErrorContains(t, result.Corrupt[0].Err, "checksum mismatch")
+	require.Equal(t, "main", result.Corrupt[0].Entries[0].Ref)
+	require.FileExists(t, file)
+
+	result, err = VerifyCache("", true, false)
+	require.NoError(t, err)
+	require.Len(t, result.Corrupt, 1)
+	require.NoFileExists(t, file)
+	require.FileExists(t, filepath.Join(GetCacheDir(), quarantineDirName, "github", "u", "r", "abc.tar.gz"))
+	require.NoDirExists(t, filepath.Join(GetCacheDir(), objectDirName, "abc"))
+	entries, err := ListCache("")
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestVerifyCacheQuarantinesForksSharingAnObject(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	archive := writeTarGz(t, []tarEntry{{name: "upstream-abc/README.md", content: "hello"}})
+	upstream := newTestRepo(serveArchive(t, archive).URL, nil)
+	upstream.Name, upstream.Ref, upstream.Hash = "upstream", "main", "abc"
+	require.NoError(t, upstream.Clone(filepath.Join(t.TempDir(), "a"), false, false))
+
+	fork := newTestRepo("http://127.0.0.1:0", nil)
+	fork.User, fork.Name, fork.Ref, fork.Hash = "someone", "fork", "main", "abc"
+	require.NoError(t, fork.Clone(filepath.Join(t.TempDir(), "b"), false, false))
+
+	// Both files are links to the stored object, so they are corrupt alike.
+	require.NoError(t, os.WriteFile(upstream.getOutputFile("abc"), []byte("garbage"), 0o644))
+
+	// Only the upstream is verified; the fork goes with the shared object.
+	result, err := VerifyCache("github:u/upstream", true, false)
+	require.NoError(t, err)
+	require.Equal(t, 1, result.Checked)
+	require.Len(t, result.Corrupt, 2)
+	for _, c := range result.Corrupt {
+		require.ErrorContains(t, c.Err, "checksum mismatch")
+		require.FileExists(t, filepath.Join(GetCacheDir(), quarantineDirName, filepath.FromSlash(c.File)))
+	}
+	require.NoFileExists(t, upstream.getOutputFile("abc"))
+	require.NoFileExists(t, fork.getOutputFile("abc"))
+	require.NoDirExists(t, filepath.Join(GetCacheDir(), objectDirName, "abc"))
+	entries, err := ListCache("")
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestVerifyCacheDecodesArchives(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	seedRepoCache(t, "github/u/r", map[string]string{"main": "aaa"}, nil, 10)
+
+	// The checksum was taken from the invalid file, so only decoding it
+	// tells that it is corrupt.
+	result, err := VerifyCache("", false, false)
+	require.NoError(t, err)
+	require.Len(t, result.Corrupt, 1)
+	require.ErrorContains(t, result.Corrupt[0].Err, "invalid archive")
+}
+
+func TestCloneVerifyRedownloadsCorruptTarball(t *testing.T) {
+	t.Setenv("DEGIT_CACHE_DIR", t.TempDir())
+	body, err := os.ReadFile(writeTarGz(t, []tarEntry{{name: "r-abc/README.md", content: "hello"}}))
+	require.NoError(t, err)
+	var downloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads.Add(1)
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	clone := func() string {
+		repo := newTestRepo(server.URL, nil)
+		repo.Ref, repo.Hash, repo.Verify = "main", "abc", true
+		dst := filepath.Join(t.TempDir(), "out")
+		require.NoError(t, repo.Clone(dst, false, false))
+		return readFile(t, filepath.Join(dst, "README.md"))
+	}
+
+	require.Equal(t, "hello", clone())
+	require.Equal(t, "hello", clone())
+	require.Equal(t, int32(1), downloads.Load())
+
+	corrupt := append([]byte{}, body...)
+	corrupt[len(corrupt)/2] ^= 0xff
+	require.NoError(t, os.WriteFile(newTestRepo(server.URL, nil).getOutputFile("abc"), corrupt, 0o644))
+
+	require.Equal(t, "hello", clone())
+	require.Equal(t, int32(2), downloads.Load())
+}